@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// Prefix applied to all the annotations this controller understands.
+const annotationPrefix = "ingress.kubernetes.io/"
+
+// Helper to load an annotation from an ingress.
+func annotation(i extensions.Ingress, key string) (string, bool) {
+	v, ok := i.ObjectMeta.Annotations[annotationPrefix+key]
+	return v, ok
+}
+
+// Helper to load an integer annotation, returning the default when it has not been set.
+func annotationInt(i extensions.Ingress, key string, def int) (int, error) {
+	v, ok := annotation(i, key)
+	if !ok {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, errors.New(fmt.Sprintf("Annotation %s%s must be a non-negative number, got: %s", annotationPrefix, key, v))
+	}
+
+	return n, nil
+}
+
+// Helper to load a boolean annotation, returning the default when it has not been set.
+func annotationBool(i extensions.Ingress, key string, def bool) (bool, error) {
+	v, ok := annotation(i, key)
+	if !ok {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("Annotation %s%s must be true or false, got: %s", annotationPrefix, key, v))
+	}
+
+	return b, nil
+}
+
+// Helper to load a comma separated list annotation.
+func annotationList(i extensions.Ingress, key string) []string {
+	v, _ := annotation(i, key)
+	return splitList(v)
+}
+
+// The annotations this controller understands, without the prefix.
+var knownAnnotations = []string{
+	"backend-protocol",
+	"backup-service",
+	"canary",
+	"canary-by-cookie",
+	"canary-by-header",
+	"canary-by-header-value",
+	"canary-weight",
+	"cors-allow-credentials",
+	"cors-allow-headers",
+	"cors-allow-methods",
+	"cors-allow-origin",
+	"cors-max-age",
+	"custom-http-errors",
+	"default-backend",
+	"enable-cors",
+	"force-ssl-redirect",
+	"from-to-www-redirect",
+	"hsts",
+	"hsts-include-subdomains",
+	"hsts-max-age",
+	"hsts-preload",
+	"limit-burst",
+	"limit-connections",
+	"limit-rpm",
+	"limit-rps",
+	"limit-whitelist",
+	"mirror-request-body",
+	"mirror-sample",
+	"mirror-target",
+	"permanent-redirect",
+	"permanent-redirect-code",
+	"proxy-next-upstream",
+	"proxy-next-upstream-timeout",
+	"proxy-next-upstream-tries",
+	"proxy-read-timeout",
+	"proxy-send-timeout",
+	"proxy-ssl-name",
+	"proxy-ssl-secret",
+	"proxy-ssl-verify",
+	"ssl-redirect",
+	"temporal-redirect",
+	"temporal-redirect-code",
+	"tls-secret",
+}
+
+// Returns the annotations with our prefix which we don't understand, mapped to the known annotation
+// each was most likely meant to be, empty if there is none close enough.
+func unknownAnnotations(i extensions.Ingress) map[string]string {
+	l := make(map[string]string)
+
+	for k := range i.ObjectMeta.Annotations {
+		if !strings.HasPrefix(k, annotationPrefix) {
+			continue
+		}
+
+		key := strings.TrimPrefix(k, annotationPrefix)
+
+		var (
+			suggestion string
+			best       = 3
+		)
+
+		for _, known := range knownAnnotations {
+			if known == key {
+				suggestion, best = "", -1
+				break
+			}
+			if d := editDistance(key, known); d < best {
+				suggestion, best = known, d
+			}
+		}
+
+		if best >= 0 {
+			l[key] = suggestion
+		}
+	}
+
+	return l
+}
+
+// Returns the number of single character edits, or swaps of adjacent characters, needed to turn one
+// string into another.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			d[i][j] = d[i-1][j-1] + cost
+			if d[i-1][j]+1 < d[i][j] {
+				d[i][j] = d[i-1][j] + 1
+			}
+			if d[i][j-1]+1 < d[i][j] {
+				d[i][j] = d[i][j-1] + 1
+			}
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+
+	return d[len(a)][len(b)]
+}
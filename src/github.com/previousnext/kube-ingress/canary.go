@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// Header and cookie names which can be used in nginx variables.
+var (
+	canaryHeaderRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	canaryCookieRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
+
+// Canary splits the traffic of a location between the primary upstream and the
+// upstream of a canary ingress.
+type Canary struct {
+	// Name used for the variables which select the upstream.
+	Name string
+
+	Primary  string
+	Upstream string
+
+	// The namespace/name of the canary ingress.
+	Ingress string
+
+	// Percentage of requests sent to the canary.
+	Weight int
+
+	// Requests with this header are sent to the canary. When no value is set the
+	// header can be "always" or "never".
+	Header      string
+	HeaderValue string
+
+	// Requests with this cookie set to "always" or "never" override the weight.
+	Cookie string
+}
+
+// Returns true if the ingress is a canary of another ingress.
+func isCanary(i extensions.Ingress) bool {
+	c, _ := annotationBool(i, "canary", false)
+	return c
+}
+
+// Loads the canary settings of an ingress.
+func parseCanary(i extensions.Ingress) (Canary, error) {
+	var (
+		c   Canary
+		err error
+	)
+
+	if c.Weight, err = annotationInt(i, "canary-weight", 0); err != nil {
+		return c, err
+	}
+	if c.Weight > 100 {
+		return c, errors.New(fmt.Sprintf("Canary weight must be between 0 and 100, got: %d", c.Weight))
+	}
+
+	c.Header, _ = annotation(i, "canary-by-header")
+	c.HeaderValue, _ = annotation(i, "canary-by-header-value")
+	c.Cookie, _ = annotation(i, "canary-by-cookie")
+
+	if c.Header != "" && !canaryHeaderRegex.MatchString(c.Header) {
+		return c, errors.New(fmt.Sprintf("Invalid canary header: %s", c.Header))
+	}
+	if strings.ContainsAny(c.HeaderValue, "\"\\;") {
+		return c, errors.New(fmt.Sprintf("Invalid canary header value: %s", c.HeaderValue))
+	}
+	if c.Cookie != "" && !canaryCookieRegex.MatchString(c.Cookie) {
+		return c, errors.New(fmt.Sprintf("Invalid canary cookie: %s", c.Cookie))
+	}
+
+	return c, nil
+}
+
+// Variable holding the upstream selected by weight.
+func (c Canary) WeightVariable() string {
+	return "$canary_" + c.Name + "_weight"
+}
+
+// Variable holding the upstream selected by cookie, falling back to the weight.
+func (c Canary) CookieVariable() string {
+	return "$canary_" + c.Name + "_cookie"
+}
+
+// Variable holding the upstream selected by header, falling back to the cookie or weight.
+func (c Canary) HeaderVariable() string {
+	return "$canary_" + c.Name + "_header"
+}
+
+// Variable which the cookie is read from.
+func (c Canary) RequestCookie() string {
+	return "$cookie_" + c.Cookie
+}
+
+// Variable which the header is read from.
+func (c Canary) RequestHeader() string {
+	return "$http_" + strings.Replace(strings.ToLower(c.Header), "-", "_", -1)
+}
+
+// Variable holding the upstream to route the request to.
+func (c Canary) Variable() string {
+	switch {
+	case c.Header != "":
+		return c.HeaderVariable()
+	case c.Cookie != "":
+		return c.CookieVariable()
+	}
+	return c.WeightVariable()
+}
+
+// Variable the header lookup falls back to.
+func (c Canary) HeaderDefault() string {
+	if c.Cookie != "" {
+		return c.CookieVariable()
+	}
+	return c.WeightVariable()
+}
+
+// Upstream which requests are passed to, a variable when the traffic is split.
+func (l Location) Target() string {
+	if l.Canary != nil {
+		return l.Canary.Variable()
+	}
+	return l.Upstream
+}
+
+// Attaches a canary ingress to the locations of the primary ingress with the same host and path.
+func (bu *Builder) addCanary(b *Backend, i extensions.Ingress) {
+	c, err := parseCanary(i)
+	if err != nil {
+		bu.skip(i, "", "", "annotation", "Skipping canary ingress %s/%s: %s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, err)
+		return
+	}
+
+	for _, r := range i.Spec.Rules {
+		if r.HTTP == nil {
+			continue
+		}
+
+		server, ok := b.Servers[r.Host]
+		if !ok {
+			bu.skip(i, r.Host, "", "canary", "Skipping canary ingress %s/%s: no primary ingress for %s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, r.Host)
+			continue
+		}
+
+		for _, pa := range r.HTTP.Paths {
+			if !bu.checkPath(i, r.Host, pa.Path) {
+				continue
+			}
+
+			n := server.location(pa.Path)
+			if n < 0 {
+				bu.skip(i, r.Host, pa.Path, "canary", "Skipping canary ingress %s/%s: no primary ingress for %s%s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, r.Host, pa.Path)
+				continue
+			}
+			if server.Locations[n].Redirect != nil {
+				bu.skip(i, r.Host, pa.Path, "canary", "Skipping canary ingress %s/%s: %s%s is a redirect", i.ObjectMeta.Namespace, i.ObjectMeta.Name, r.Host, pa.Path)
+				continue
+			}
+			if server.Locations[n].Canary != nil {
+				bu.skip(i, r.Host, pa.Path, "conflict", "Skipping canary ingress %s/%s: %s%s already has a canary", i.ObjectMeta.Namespace, i.ObjectMeta.Name, r.Host, pa.Path)
+				continue
+			}
+
+			port, err := bu.Services.ResolvePort(MergeNameNameSpace(i.ObjectMeta.Namespace, pa.Backend.ServiceName), pa.Backend.ServicePort)
+			if err != nil {
+				bu.skip(i, r.Host, pa.Path, "port", "Skipping canary ingress %s/%s for %s%s: %s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, r.Host, pa.Path, err)
+				continue
+			}
+
+			name, list, err := bu.Services.Upstream(MergeNameNameSpace(i.ObjectMeta.Namespace, pa.Backend.ServiceName), port)
+			if err != nil {
+				bu.skip(i, r.Host, pa.Path, "service", "Skipping canary ingress %s/%s for %s%s: %s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, r.Host, pa.Path, err)
+				continue
+			}
+			b.Upstreams[name] = list
+
+			canary := c
+			canary.Name = zoneName(i.ObjectMeta.Namespace, i.ObjectMeta.Name+"/"+r.Host+pa.Path)
+			canary.Primary = server.Locations[n].Upstream
+			canary.Upstream = name
+			canary.Ingress = i.ObjectMeta.Namespace + "/" + i.ObjectMeta.Name
+
+			server.Locations[n].Canary = &canary
+			b.Canaries[canary.Name] = canary
+		}
+
+		b.Servers[r.Host] = server
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/util"
+)
+
+// Controller watches the ingresses and keeps nginx in sync with them.
+type Controller struct {
+	Client  client.IngressInterface
+	Builder *Builder
+	Nginx   *Nginx
+	Queue   *SyncQueue
+
+	// Writes the status of the ingresses and records events for them.
+	Publisher *Publisher
+
+	// File the last applied backend is saved to, so it can be served after a restart.
+	SnapshotFile string
+
+	// Percentage of the routes a sync may drop before it is refused, 0 to allow any.
+	MaxRouteDrop int
+
+	// Files mapping external ports to namespace/service:port for the stream module.
+	TCPServicesFile string
+	UDPServicesFile string
+
+	mu     sync.Mutex
+	force  bool
+	listed bool
+	ings   []extensions.Ingress
+	tcp    map[string]string
+	udp    map[string]string
+
+	// The ingresses, backend and problems of the last applied sync, used to explain requests.
+	appliedIngs     []extensions.Ingress
+	applied         Backend
+	appliedProblems []Problem
+}
+
+// Polls for changes to the ingresses and stream services, queueing a sync when they change.
+func (c *Controller) Start(ctx context.Context) {
+	rl := util.NewTokenBucketRateLimiter(0.1, 1)
+
+	for {
+		if !accept(ctx, rl) {
+			return
+		}
+
+		// Query for the current list of ingresses.
+		ings, err := c.Client.List(labels.Everything(), fields.Everything())
+		if err != nil {
+			fmt.Printf("Error retrieving ingresses: %v\n", err)
+			continue
+		}
+
+		c.mu.Lock()
+
+		prev, listed := c.ings, c.listed
+
+		changed := !c.listed || !reflect.DeepEqual(ings.Items, c.ings)
+		c.listed = true
+		c.ings = ings.Items
+
+		// Load the latest TCP and UDP port mappings, keeping the previous ones if they cannot be read.
+		if tcp, err := LoadStreamServices(c.TCPServicesFile); err != nil {
+			fmt.Printf("Error loading TCP services: %v\n", err)
+		} else if !reflect.DeepEqual(tcp, c.tcp) {
+			c.tcp = tcp
+			changed = true
+		}
+		if udp, err := LoadStreamServices(c.UDPServicesFile); err != nil {
+			fmt.Printf("Error loading UDP services: %v\n", err)
+		} else if !reflect.DeepEqual(udp, c.udp) {
+			c.udp = udp
+			changed = true
+		}
+
+		c.mu.Unlock()
+
+		if changed {
+			c.Queue.Enqueue()
+		}
+
+		// Events are only recorded for changes we have seen happen.
+		if listed {
+			c.Publisher.Changes(prev, ings.Items)
+		}
+
+		c.Publisher.UpdateStatus(ings.Items)
+	}
+}
+
+// Queues a sync which is applied regardless of how many routes it drops.
+func (c *Controller) Force() {
+	c.mu.Lock()
+	c.force = true
+	c.mu.Unlock()
+
+	c.Queue.Enqueue()
+}
+
+// Returned by Sync until the ingresses, services and secrets have been loaded.
+var ErrNotReady = errors.New("Waiting for the ingresses, services and secrets to be loaded. Not reloading the nginx daemon.")
+
+// Applies the latest ingresses and services to nginx, returns ErrNotChanged or ErrNotReady
+// when nginx was not reloaded.
+func (c *Controller) Sync() error {
+	c.mu.Lock()
+	var (
+		listed = c.listed
+		ings   = c.ings
+	)
+	c.Builder.TCPServices = c.tcp
+	c.Builder.UDPServices = c.udp
+	c.mu.Unlock()
+
+	// Wait for everything to be loaded from the API, otherwise we would replace the
+	// configuration from the snapshot with a partial one.
+	if !listed || !c.Builder.Services.Synced() || !c.Builder.Secrets.Synced() {
+		return ErrNotReady
+	}
+
+	// Without any ingresses only the default server is rendered, so routes which have been
+	// removed stop being served.
+	if len(ings) <= 0 {
+		fmt.Println("No ingresses were found")
+	}
+
+	backend := c.Builder.Build(ings)
+	problems := c.Builder.Problems
+
+	c.mu.Lock()
+	force := c.force
+	c.force = false
+	c.mu.Unlock()
+
+	// Guard against a partial view of the cluster wiping out most of the routes.
+	if dropped := droppedRoutes(c.Nginx.Prev, backend); !force && c.MaxRouteDrop > 0 && dropped > c.MaxRouteDrop {
+		syncRefused.Inc()
+		return errors.New(fmt.Sprintf("Refusing to apply a configuration which drops %d%% of the routes, force a sync to apply it", dropped))
+	}
+
+	// The certificates need to be in place before nginx loads them.
+	if err := c.Builder.Secrets.Write(backend); err != nil {
+		return err
+	}
+
+	// Add the upstreams and servers to the nginx configuration.
+	c.Nginx.SetBackend(backend)
+
+	err := c.Nginx.Reload()
+	if err == ErrNotChanged {
+		fmt.Println(err)
+		c.Builder.Secrets.RemoveStale(backend)
+		c.setApplied(ings, backend, problems)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Builder.Secrets.RemoveStale(backend)
+	c.setApplied(ings, backend, problems)
+
+	fmt.Println("Successfully applied the updated Ingresses to Nginx")
+
+	if c.SnapshotFile != "" {
+		if err := SaveSnapshot(c.SnapshotFile, c.Nginx.Prev); err != nil {
+			fmt.Printf("Failed to save the snapshot: %v\n", err)
+		}
+	}
+
+	return nil
+}
+
+// Returns the latest ingresses, false if they haven't been listed yet.
+func (c *Controller) Ingresses() ([]extensions.Ingress, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ings, c.listed
+}
+
+// Records what was applied by a sync.
+func (c *Controller) setApplied(ings []extensions.Ingress, b Backend, problems []Problem) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.appliedIngs = ings
+	c.applied = b
+	c.appliedProblems = problems
+}
+
+// Explains how the applied configuration routes a request.
+func (c *Controller) Explain(host, path string) Explanation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Explain(c.appliedIngs, c.applied, c.appliedProblems, c.Builder.Services, host, path)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+func TestDroppedRoutes(t *testing.T) {
+	var (
+		svcs = map[string][]string{
+			"default-web": []string{"1.2.3.4:80"},
+		}
+		a = testIngress("default", "a", "a.com", "/", "web", nil)
+		b = testIngress("default", "b", "b.com", "/", "web", nil)
+		c = testIngress("default", "c", "c.com", "/", "web", nil)
+		d = testIngress("default", "d", "d.com", "/", "web", nil)
+	)
+
+	prev := testBuild(Config{}, svcs, nil, a, b, c, d)
+	assert.Equal(t, 0, droppedRoutes(Backend{}, prev), "Nothing is dropped without previous routes")
+	assert.Equal(t, 0, droppedRoutes(prev, testBuild(Config{}, svcs, nil, a, b, c, d)))
+	assert.Equal(t, 25, droppedRoutes(prev, testBuild(Config{}, svcs, nil, a, b, c)))
+	assert.Equal(t, 75, droppedRoutes(prev, testBuild(Config{}, svcs, nil, a)))
+	assert.Equal(t, 100, droppedRoutes(prev, testBuild(Config{}, svcs, nil)))
+}
+
+func TestControllerSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-ingress")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	binary := filepath.Join(dir, "nginx")
+	assert.Nil(t, ioutil.WriteFile(binary, []byte(fakeNginx), 0755))
+
+	cfgFile := *cliCfg
+	*cliCfg = filepath.Join(dir, "nginx.conf")
+	defer func() { *cliCfg = cfgFile }()
+
+	n, err := NewNginx(Config{Port: "80"})
+	assert.Nil(t, err)
+	n.Process = NewProcess(binary, *cliCfg, false)
+	defer n.Process.Quit(time.Second)
+
+	c := &Controller{
+		Builder: &Builder{
+			Services: &Services{List: map[string][]string{"default-web": []string{"1.2.3.4:80"}}},
+			Secrets:  &Secrets{},
+		},
+		Nginx:        n,
+		Queue:        NewSyncQueue(time.Second, time.Second, nil),
+		MaxRouteDrop: 50,
+	}
+
+	assert.Equal(t, ErrNotReady, c.Sync())
+	assert.False(t, n.Process.Running(), "Nothing is applied until everything has been loaded")
+
+	c.listed = true
+	c.Builder.Services.synced = true
+	c.Builder.Secrets.synced = true
+	c.ings = []extensions.Ingress{
+		testIngress("default", "a", "a.com", "/", "web", nil),
+		testIngress("default", "b", "b.com", "/", "web", nil),
+	}
+	assert.Nil(t, c.Sync())
+	assert.Len(t, n.Prev.Servers, 2)
+
+	// Give the shell a moment to install its traps before it is reloaded.
+	time.Sleep(200 * time.Millisecond)
+
+	c.ings = nil
+	assert.Equal(t, "Refusing to apply a configuration which drops 100% of the routes, force a sync to apply it", c.Sync().Error())
+	assert.Len(t, n.Prev.Servers, 2, "The routes are still served")
+
+	// Once forced, removing every ingress leaves only the default server.
+	c.Force()
+	assert.Nil(t, c.Sync())
+	assert.Empty(t, n.Prev.Servers)
+
+	cfg, err := ioutil.ReadFile(*cliCfg)
+	assert.Nil(t, err)
+	assert.Contains(t, string(cfg), "default_server")
+	assert.Contains(t, string(cfg), "return 404;")
+}
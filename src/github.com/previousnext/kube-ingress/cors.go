@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// Defaults used when the ingress does not set them.
+const (
+	corsDefaultMethods = "GET, PUT, POST, DELETE, PATCH, OPTIONS"
+	corsDefaultHeaders = "DNT, Keep-Alive, User-Agent, X-Requested-With, If-Modified-Since, Cache-Control, Content-Type, Authorization"
+	corsDefaultMaxAge  = 1728000
+)
+
+// CORS answers preflight requests at the edge and adds the CORS headers to responses.
+type CORS struct {
+	// Name used for the variable holding the allowed origin.
+	Name string
+
+	// Origins allowed to make requests, entries starting with ~ are regular expressions.
+	// An empty list allows any origin.
+	Origins []string
+
+	Methods     string
+	Headers     string
+	Credentials bool
+	MaxAge      int
+}
+
+// Loads the CORS settings of an ingress, returns nil if CORS is not enabled.
+func parseCORS(i extensions.Ingress) (*CORS, error) {
+	enabled, err := annotationBool(i, "enable-cors", false)
+	if err != nil || !enabled {
+		return nil, err
+	}
+
+	c := &CORS{
+		Name:    zoneName(i.ObjectMeta.Namespace, i.ObjectMeta.Name),
+		Methods: corsDefaultMethods,
+		Headers: corsDefaultHeaders,
+	}
+
+	for _, o := range annotationList(i, "cors-allow-origin") {
+		if o == "*" {
+			c.Origins = nil
+			break
+		}
+		if strings.HasPrefix(o, "~") {
+			if _, err := regexp.Compile(strings.TrimPrefix(o, "~")); err != nil {
+				return nil, errors.New(fmt.Sprintf("Invalid origin expression %s: %v", o, err))
+			}
+		}
+		if strings.ContainsAny(o, "\" ;{}") {
+			return nil, errors.New(fmt.Sprintf("Invalid origin: %s", o))
+		}
+		c.Origins = append(c.Origins, o)
+	}
+
+	if v, ok := annotation(i, "cors-allow-methods"); ok {
+		c.Methods = v
+	}
+	if v, ok := annotation(i, "cors-allow-headers"); ok {
+		c.Headers = v
+	}
+	if strings.ContainsAny(c.Methods+c.Headers, "\";{}$") {
+		return nil, errors.New("Invalid characters in the allowed methods or headers")
+	}
+
+	if c.Credentials, err = annotationBool(i, "cors-allow-credentials", false); err != nil {
+		return nil, err
+	}
+	// Credentialed requests are only answered for the origins which are listed.
+	if c.Credentials && len(c.Origins) == 0 {
+		return nil, errors.New("Allowing credentials requires the origins to be listed with cors-allow-origin")
+	}
+	if c.MaxAge, err = annotationInt(i, "cors-max-age", corsDefaultMaxAge); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Variable holding the value of the Access-Control-Allow-Origin header, empty when
+// the origin is not allowed.
+func (c CORS) OriginVariable() string {
+	return "$cors_" + c.Name + "_origin"
+}
+
+// Value of the Access-Control-Allow-Origin header, the request origin is only echoed
+// when it matches one of the listed origins.
+func (c CORS) AllowOrigin() string {
+	if len(c.Origins) == 0 {
+		return "*"
+	}
+	return c.OriginVariable()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Name of the upstream pointing at the built in default backend. Services are
+// named namespace-service so this cannot collide with them.
+const defaultBackendUpstream = "kube_ingress_default_backend"
+
+// File in the certificate directory holding the certificate of the default server. Certificates of
+// secrets are named namespace-name so this cannot collide with them.
+const defaultCertFile = "kube_ingress_default.pem"
+
+// Serves requests for unknown hosts along with the error pages of the ingresses.
+func DefaultBackend(w http.ResponseWriter, r *http.Request) {
+	code := http.StatusNotFound
+
+	// Error pages are requested by nginx with the original status code.
+	if c, err := strconv.Atoi(r.Header.Get("X-Code")); err == nil && c >= 400 && c <= 599 {
+		code = c
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, "%d %s\n", code, http.StatusText(code))
+}
+
+// Starts the built in default backend on the loopback interface, serving until the context is cancelled.
+// Every request is proxied from nginx, so it only serves the error pages, the health of the controller is
+// on the status port.
+func StartDefaultBackend(ctx context.Context, port string) {
+	err := serve(ctx, &http.Server{Addr: "127.0.0.1:" + port, Handler: http.HandlerFunc(DefaultBackend)})
+	if err != nil {
+		fmt.Printf("Default backend stopped: %v\n", err)
+	}
+}
+
+// Writes the self-signed certificate the default server answers HTTPS requests for unknown hosts with,
+// keeping the existing one.
+func WriteDefaultCertificate(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "kube-ingress default server"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	var data bytes.Buffer
+	pem.Encode(&data, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pem.Encode(&data, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data.Bytes(), 0600)
+}
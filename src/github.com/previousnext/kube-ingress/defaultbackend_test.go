@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultBackend(t *testing.T) {
+	w := httptest.NewRecorder()
+	DefaultBackend(w, httptest.NewRequest("GET", "http://unknown.com/", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "Unknown hosts are not found")
+
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r.Header.Set("X-Code", "503")
+	w = httptest.NewRecorder()
+	DefaultBackend(w, r)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "Error pages keep the original code")
+	assert.Equal(t, "503 Service Unavailable\n", w.Body.String())
+
+	r.Header.Set("X-Code", "200")
+	w = httptest.NewRecorder()
+	DefaultBackend(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code, "Only error codes are served")
+}
+
+func TestDefaultCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-ingress")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ssl", defaultCertFile)
+	assert.Nil(t, WriteDefaultCertificate(path))
+
+	_, err = tls.LoadX509KeyPair(path, path)
+	assert.Nil(t, err, "The file holds the certificate and key")
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Nil(t, WriteDefaultCertificate(path))
+	again, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, data, again, "The existing certificate is kept")
+
+	// Both default servers answer HTTPS requests for unknown hosts.
+	for _, port := range []string{"", "8181"} {
+		n, err := NewNginx(Config{Port: "80", SSLPort: "443", DefaultBackendPort: port, DefaultSSLCert: path})
+		assert.Nil(t, err)
+		n.SetBackend(Backend{})
+
+		var buf bytes.Buffer
+		assert.Nil(t, n.Render(&buf))
+		assert.Contains(t, buf.String(), "listen      443 ssl default_server;")
+		assert.Contains(t, buf.String(), "ssl_certificate     "+path+";")
+	}
+}
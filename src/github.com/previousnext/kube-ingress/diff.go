@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// Change is a single difference between two backends.
+type Change struct {
+	// What changed, a server, location, upstream or stream.
+	Kind string `json:"kind"`
+
+	// Host, host and path, upstream name or port of what changed.
+	Name string `json:"name"`
+
+	// Whether it was added, removed or changed.
+	Action string `json:"action"`
+
+	// Servers added to and removed from upstreams.
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+func (c Change) String() string {
+	s := fmt.Sprintf("%s %s %s", c.Kind, c.Name, c.Action)
+	for _, a := range c.Added {
+		s += " +" + a
+	}
+	for _, r := range c.Removed {
+		s += " -" + r
+	}
+	return s
+}
+
+// Diff is the difference between the configuration nginx was running and a new one.
+type Diff struct {
+	Time    time.Time `json:"time"`
+	Changes []Change  `json:"changes"`
+
+	// Unified diff of the rendered configuration.
+	Text string `json:"text"`
+}
+
+// DiffLog keeps the last diff so it can be served on the debug endpoint.
+type DiffLog struct {
+	mu   sync.Mutex
+	last Diff
+}
+
+func (d *DiffLog) Set(diff Diff) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.last = diff
+}
+
+func (d *DiffLog) Last() Diff {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.last
+}
+
+// Returns the changes to the servers, locations, upstreams and streams between two backends.
+func DiffBackends(prev, next Backend) []Change {
+	var l []Change
+
+	for _, host := range diffKeys(prev.Servers, next.Servers) {
+		p, inPrev := prev.Servers[host]
+		n, inNext := next.Servers[host]
+
+		switch {
+		case !inPrev:
+			l = append(l, Change{Kind: "server", Name: host, Action: "added"})
+		case !inNext:
+			l = append(l, Change{Kind: "server", Name: host, Action: "removed"})
+		default:
+			// Settings of the server itself, the locations are compared on their own.
+			ps, ns := p, n
+			ps.Locations, ns.Locations = nil, nil
+			if !reflect.DeepEqual(ps, ns) {
+				l = append(l, Change{Kind: "server", Name: host, Action: "changed"})
+			}
+		}
+
+		l = append(l, diffLocations(host, p.Locations, n.Locations)...)
+	}
+
+	for _, name := range diffKeys(prev.Upstreams, next.Upstreams) {
+		p, inPrev := prev.Upstreams[name]
+		n, inNext := next.Upstreams[name]
+
+		c := Change{Kind: "upstream", Name: name, Action: "changed"}
+		switch {
+		case !inPrev:
+			c.Action = "added"
+		case !inNext:
+			c.Action = "removed"
+		}
+
+		c.Added, c.Removed = diffMembers(p, n)
+		if c.Action != "changed" || len(c.Added) > 0 || len(c.Removed) > 0 {
+			l = append(l, c)
+		}
+	}
+
+	l = append(l, diffStreams("tcp", prev.TCP, next.TCP)...)
+	l = append(l, diffStreams("udp", prev.UDP, next.UDP)...)
+
+	return l
+}
+
+// Returns the changes to the locations of a host.
+func diffLocations(host string, prev, next []Location) []Change {
+	var (
+		l  []Change
+		ps = make(map[string]Location)
+		ns = make(map[string]Location)
+	)
+
+	for _, location := range prev {
+		ps[location.Path] = location
+	}
+	for _, location := range next {
+		ns[location.Path] = location
+	}
+
+	for _, path := range diffKeys(ps, ns) {
+		p, inPrev := ps[path]
+		n, inNext := ns[path]
+
+		switch {
+		case !inPrev:
+			l = append(l, Change{Kind: "location", Name: host + path, Action: "added"})
+		case !inNext:
+			l = append(l, Change{Kind: "location", Name: host + path, Action: "removed"})
+		case !reflect.DeepEqual(p, n):
+			l = append(l, Change{Kind: "location", Name: host + path, Action: "changed"})
+		}
+	}
+
+	return l
+}
+
+// Returns the changes to the streams exposed on ports.
+func diffStreams(kind string, prev, next map[string]Stream) []Change {
+	var l []Change
+
+	for _, port := range diffKeys(prev, next) {
+		p, inPrev := prev[port]
+		n, inNext := next[port]
+
+		c := Change{Kind: kind, Name: port, Action: "changed"}
+		switch {
+		case !inPrev:
+			c.Action = "added"
+		case !inNext:
+			c.Action = "removed"
+		}
+
+		c.Added, c.Removed = diffMembers(p.Addresses, n.Addresses)
+		if c.Action != "changed" || len(c.Added) > 0 || len(c.Removed) > 0 || p.Service != n.Service {
+			l = append(l, c)
+		}
+	}
+
+	return l
+}
+
+// Returns the servers which have been added and removed.
+func diffMembers(prev, next []string) ([]string, []string) {
+	var (
+		added   []string
+		removed []string
+		ps      = make(map[string]bool)
+		ns      = make(map[string]bool)
+	)
+
+	for _, a := range prev {
+		ps[a] = true
+	}
+	for _, a := range next {
+		ns[a] = true
+		if !ps[a] {
+			added = append(added, a)
+		}
+	}
+	for _, a := range prev {
+		if !ns[a] {
+			removed = append(removed, a)
+		}
+	}
+
+	return added, removed
+}
+
+// Returns the sorted keys of two maps with string keys.
+func diffKeys(a, b interface{}) []string {
+	seen := make(map[string]bool)
+
+	for _, m := range []interface{}{a, b} {
+		for _, k := range reflect.ValueOf(m).MapKeys() {
+			seen[k.String()] = true
+		}
+	}
+
+	var keys []string
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Returns a unified diff of two rendered configurations, ignoring blank lines.
+func diffText(prev, next string) string {
+	text, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(withoutBlankLines(prev)),
+		B:        difflib.SplitLines(withoutBlankLines(next)),
+		FromFile: "previous",
+		ToFile:   "new",
+		Context:  3,
+	})
+	if err != nil {
+		return err.Error()
+	}
+	return text
+}
+
+func withoutBlankLines(s string) string {
+	var b bytes.Buffer
+	for _, line := range strings.Split(s, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffBackends(t *testing.T) {
+	prev := Backend{
+		Servers: map[string]Server{
+			"a.example.com": Server{
+				Locations: []Location{
+					Location{Path: "/", Upstream: "default-a-80"},
+					Location{Path: "/old", Upstream: "default-old-80"},
+				},
+			},
+			"b.example.com": Server{
+				Locations: []Location{
+					Location{Path: "/", Upstream: "default-b-80"},
+				},
+			},
+		},
+		Upstreams: map[string][]string{
+			"default-a-80":   []string{"1.1.1.1:80", "1.1.1.2:80"},
+			"default-b-80":   []string{"1.1.1.3:80"},
+			"default-old-80": []string{"1.1.1.4:80"},
+		},
+		TCP: map[string]Stream{
+			"5432": Stream{Service: "default/postgres:5432", Addresses: []string{"1.1.1.5:5432"}},
+		},
+	}
+
+	next := Backend{
+		Servers: map[string]Server{
+			"a.example.com": Server{
+				HTTP2: true,
+				Locations: []Location{
+					Location{Path: "/", Upstream: "default-a-8080"},
+					Location{Path: "/new", Upstream: "default-new-80"},
+				},
+			},
+			"c.example.com": Server{
+				Locations: []Location{
+					Location{Path: "/", Upstream: "default-b-80"},
+				},
+			},
+		},
+		Upstreams: map[string][]string{
+			"default-a-8080": []string{"1.1.1.1:8080"},
+			"default-b-80":   []string{"1.1.1.3:80", "1.1.1.6:80"},
+			"default-new-80": []string{"1.1.1.7:80"},
+		},
+	}
+
+	var l []string
+	for _, c := range DiffBackends(prev, next) {
+		l = append(l, c.String())
+	}
+
+	assert.Equal(t, []string{
+		"server a.example.com changed",
+		"location a.example.com/ changed",
+		"location a.example.com/new added",
+		"location a.example.com/old removed",
+		"server b.example.com removed",
+		"location b.example.com/ removed",
+		"server c.example.com added",
+		"location c.example.com/ added",
+		"upstream default-a-80 removed -1.1.1.1:80 -1.1.1.2:80",
+		"upstream default-a-8080 added +1.1.1.1:8080",
+		"upstream default-b-80 changed +1.1.1.6:80",
+		"upstream default-new-80 added +1.1.1.7:80",
+		"upstream default-old-80 removed -1.1.1.4:80",
+		"tcp 5432 removed -1.1.1.5:5432",
+	}, l)
+
+	assert.Empty(t, DiffBackends(next, next), "Nothing changed")
+}
+
+func TestDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-ingress")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cfgFile := *cliCfg
+	*cliCfg = filepath.Join(dir, "nginx.conf")
+	defer func() { *cliCfg = cfgFile }()
+
+	n, err := NewNginx(Config{Port: "80", DryRun: true})
+	assert.Nil(t, err)
+
+	n.SetBackend(Backend{
+		Servers: map[string]Server{
+			"a.example.com": Server{
+				Locations: []Location{
+					Location{Path: "/", Upstream: "default-a-80"},
+				},
+			},
+		},
+		Upstreams: map[string][]string{
+			"default-a-80": []string{"1.1.1.1:80"},
+		},
+	})
+
+	// Without a process nginx would fail to reload if it were attempted.
+	assert.Nil(t, n.Reload())
+
+	_, err = os.Stat(*cliCfg)
+	assert.True(t, os.IsNotExist(err), "The configuration was not written")
+
+	d := n.Diffs.Last()
+	assert.Equal(t, []Change{
+		Change{Kind: "server", Name: "a.example.com", Action: "added"},
+		Change{Kind: "location", Name: "a.example.com/", Action: "added"},
+		Change{Kind: "upstream", Name: "default-a-80", Action: "added", Added: []string{"1.1.1.1:80"}},
+	}, d.Changes)
+	assert.Contains(t, d.Text, "+    upstream default-a-80 {")
+	assert.Contains(t, d.Text, "+        server_name a.example.com;")
+
+	assert.Equal(t, ErrNotChanged, n.Reload(), "The dry run is compared with what was rendered last")
+}
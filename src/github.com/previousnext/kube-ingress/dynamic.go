@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// Lua module which balances requests across the servers pushed by the controller. The
+// servers are stored in a shared dict so they survive reloads and are picked up by
+// every worker.
+const luaBalancer = `local balancer = require("ngx.balancer")
+local cjson = require("cjson.safe")
+
+local _M = {}
+
+local dict = ngx.shared.kube_ingress_upstreams
+local upstreams = {}
+local version = -1
+
+-- Loads the upstreams into the worker when they have changed.
+local function sync()
+  local v = dict:get("version") or 0
+  if v == version then
+    return
+  end
+
+  local data = cjson.decode(dict:get("upstreams") or "{}")
+  if data then
+    upstreams = data
+  end
+  version = v
+end
+
+function _M.init_worker()
+  sync()
+  ngx.timer.every(1, sync)
+end
+
+-- Stores the upstreams posted by the controller.
+function _M.configure()
+  if ngx.req.get_method() ~= "POST" then
+    return ngx.exit(ngx.HTTP_NOT_ALLOWED)
+  end
+
+  ngx.req.read_body()
+  local body = ngx.req.get_body_data()
+  if not body or not cjson.decode(body) then
+    return ngx.exit(ngx.HTTP_BAD_REQUEST)
+  end
+
+  local ok, err = dict:set("upstreams", body)
+  if not ok then
+    ngx.log(ngx.ERR, "failed to store the upstreams: ", err)
+    return ngx.exit(ngx.HTTP_INTERNAL_SERVER_ERROR)
+  end
+  dict:incr("version", 1, 0)
+
+  ngx.status = ngx.HTTP_CREATED
+  ngx.say("ok")
+end
+
+-- Picks the server for a request, the client address decides the first server tried
+-- so clients stick to the same pod. Retries move on to the next server and finally
+-- to the backup servers.
+function _M.balance(name)
+  sync()
+
+  local u = upstreams[name]
+  local servers = (u and u.servers) or {}
+  local backup = (u and u.backup) or {}
+  local total = #servers + #backup
+  if total == 0 then
+    ngx.log(ngx.ERR, "no servers for upstream ", name)
+    return ngx.exit(ngx.HTTP_BAD_GATEWAY)
+  end
+
+  local ctx = ngx.ctx
+  local try = ctx.kube_ingress_try or 0
+  ctx.kube_ingress_try = try + 1
+
+  if try == 0 and total > 1 then
+    balancer.set_more_tries(total - 1)
+  end
+
+  local addr
+  if try < #servers then
+    addr = servers[(ngx.crc32_long(ngx.var.remote_addr) + try) % #servers + 1]
+  else
+    addr = backup[(try - #servers) % #backup + 1]
+  end
+
+  local host, port = addr:match("^(.+):(%d+)$")
+  local ok, err = balancer.set_current_peer(host, tonumber(port))
+  if not ok then
+    ngx.log(ngx.ERR, "failed to set the peer ", addr, ": ", err)
+    return ngx.exit(ngx.HTTP_INTERNAL_SERVER_ERROR)
+  end
+end
+
+return _M
+`
+
+// DynamicUpstream is the set of servers pushed to nginx for an upstream.
+type DynamicUpstream struct {
+	Servers []string `json:"servers"`
+	Backup  []string `json:"backup,omitempty"`
+}
+
+// Builds the servers of every upstream in the backend.
+func dynamicUpstreams(b Backend) map[string]DynamicUpstream {
+	l := make(map[string]DynamicUpstream)
+
+	for name, addrs := range b.Upstreams {
+		l[name] = DynamicUpstream{Servers: addrs}
+	}
+
+	for name, backup := range b.Backups {
+		l[name] = DynamicUpstream{
+			Servers: b.Upstreams[backup.Primary],
+			Backup:  b.Upstreams[backup.Backup],
+		}
+	}
+
+	return l
+}
+
+// Helper to strip the servers from a backend, leaving only the parts which need a reload.
+func withoutEndpoints(b Backend) Backend {
+	upstreams := make(map[string][]string)
+	for name := range b.Upstreams {
+		upstreams[name] = nil
+	}
+
+	b.Upstreams = upstreams
+	return b
+}
+
+// Returns true if the servers of the upstreams have not been pushed to nginx yet.
+func (n *Nginx) pendingUpstreams() bool {
+	return n.Config.DynamicUpstreams && !reflect.DeepEqual(dynamicUpstreams(n.New), n.posted)
+}
+
+// Pushes the servers of the new backend to nginx.
+func (n *Nginx) postUpstreams() error {
+	l := dynamicUpstreams(n.New)
+
+	body, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	c := http.Client{Timeout: 5 * time.Second}
+
+	resp, err := c.Post("http://127.0.0.1:"+n.Config.DynamicUpstreamsPort+"/configuration/upstreams", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to update the upstreams: %v", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return errors.New(fmt.Sprintf("Failed to update the upstreams: %s", resp.Status))
+	}
+
+	n.posted = l
+	if n.Process != nil {
+		n.postedStart = n.Process.Starts()
+	}
+
+	return nil
+}
+
+// Pushes the servers of the new backend once nginx is accepting them, it takes a moment to
+// start listening after being started or reloaded.
+func (n *Nginx) waitPostUpstreams() error {
+	var err error
+
+	for i := 0; i < 5; i++ {
+		if err = n.postUpstreams(); err == nil {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+
+	return err
+}
+
+// Writes the Lua balancer for nginx to load.
+func (n *Nginx) writeBalancer() error {
+	if err := os.MkdirAll(n.Config.LuaDir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(n.Config.LuaDir, "kube_ingress_balancer.lua")
+	if existing, err := ioutil.ReadFile(path); err == nil && string(existing) == luaBalancer {
+		return nil
+	}
+	return ioutil.WriteFile(path, []byte(luaBalancer), 0644)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// CustomErrors sends the responses with these status codes to an error service.
+type CustomErrors struct {
+	Codes []int
+
+	// Upstream which renders the error pages.
+	Upstream string
+
+	// CORS headers added to the error pages, nil if CORS is not enabled.
+	CORS *CORS
+}
+
+// ErrorPage is an internal location which renders the error page for a status code.
+type ErrorPage struct {
+	Code     int
+	Upstream string
+	CORS     *CORS
+}
+
+// Name of the internal location which serves the error page for a code.
+func (c CustomErrors) Location(code int) string {
+	// Ingresses with their own CORS settings need their own error locations.
+	if c.CORS != nil {
+		return fmt.Sprintf("@custom_%s_%d_%s", safeName(c.Upstream), code, c.CORS.Name)
+	}
+	return fmt.Sprintf("@custom_%s_%d", safeName(c.Upstream), code)
+}
+
+// Helper to parse a list of status codes.
+func parseStatusCodes(l []string) ([]int, error) {
+	var codes []int
+
+	for _, v := range l {
+		c, err := strconv.Atoi(v)
+		if err != nil || c < 400 || c > 599 {
+			return nil, errors.New(fmt.Sprintf("Invalid error status code: %s", v))
+		}
+		codes = append(codes, c)
+	}
+
+	return codes, nil
+}
+
+// Loads the custom errors of an ingress, returns nil if none are intercepted.
+func (bu *Builder) customErrors(b *Backend, i extensions.Ingress) (*CustomErrors, error) {
+	codes := bu.Config.CustomHTTPErrors
+
+	if _, ok := annotation(i, "custom-http-errors"); ok {
+		c, err := parseStatusCodes(annotationList(i, "custom-http-errors"))
+		if err != nil {
+			return nil, err
+		}
+		codes = c
+	}
+
+	if len(codes) == 0 {
+		return nil, nil
+	}
+
+	ce := &CustomErrors{
+		Codes:    codes,
+		Upstream: defaultBackendUpstream,
+	}
+
+	// A service from the same namespace can render the error pages instead of the built in backend.
+	if svc, ok := annotation(i, "default-backend"); ok {
+		name := MergeNameNameSpace(i.ObjectMeta.Namespace, svc)
+
+		list, err := bu.Services.Get(name)
+		if err != nil {
+			bu.report(i, SeverityError, "service", "Using the default error pages for ingress %s/%s: %s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, err)
+			return nil, nil
+		}
+
+		b.Upstreams[name] = list
+		ce.Upstream = name
+
+		return ce, nil
+	}
+
+	// Without the built in backend there is nothing to render the error pages.
+	if bu.Config.DefaultBackendPort == "" {
+		return nil, errors.New("The built in default backend is disabled, use the default-backend annotation to render the error pages")
+	}
+
+	return ce, nil
+}
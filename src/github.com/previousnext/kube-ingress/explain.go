@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+// Candidate is a path of an ingress rule which could have served a request.
+type Candidate struct {
+	// The namespace/name of the ingress and the index of the rule.
+	Ingress string `json:"ingress"`
+	Rule    int    `json:"rule"`
+
+	Host    string `json:"host"`
+	Path    string `json:"path"`
+	Service string `json:"service"`
+	Port    string `json:"port"`
+	Canary  bool   `json:"canary,omitempty"`
+
+	// Why the candidate does not serve the request, empty when it does.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Explanation describes how nginx routes a request and why.
+type Explanation struct {
+	Host string `json:"host"`
+	Path string `json:"path"`
+
+	// The server name which matched the host, empty when the default server answers.
+	Server string `json:"server"`
+
+	// The location which matched the path, nil when none did.
+	Location *Location `json:"location,omitempty"`
+
+	// The pods of the upstream, and the ones of the backup service.
+	Endpoints       []string `json:"endpoints"`
+	BackupEndpoints []string `json:"backupEndpoints,omitempty"`
+
+	Candidates []Candidate `json:"candidates"`
+
+	// Anything else affecting the request, eg. redirects.
+	Notes []string `json:"notes,omitempty"`
+}
+
+// Explains which ingress rule serves a request for a host and path, following the way nginx selects the
+// server and location, and why the other rules which match the request lost.
+func Explain(ings []extensions.Ingress, b Backend, problems []Problem, svcs *Services, host, path string) Explanation {
+	e := Explanation{
+		Host: hostWithoutPort(host),
+		Path: path,
+	}
+
+	server, ok := matchServer(b, e.Host)
+	if !ok {
+		e.Notes = append(e.Notes, "No server matches the host, the default server answers the request")
+	} else {
+		e.Server = server
+		e.explainServer(b, b.Servers[server])
+	}
+
+	for _, i := range ings {
+		id := i.ObjectMeta.Namespace + "/" + i.ObjectMeta.Name
+
+		for n, r := range i.Spec.Rules {
+			if r.HTTP == nil || (r.Host != e.Server && r.Host != e.Host) {
+				continue
+			}
+
+			for _, pa := range r.HTTP.Paths {
+				if !strings.HasPrefix(e.Path, pa.Path) {
+					continue
+				}
+
+				c := Candidate{
+					Ingress: id,
+					Rule:    n,
+					Host:    r.Host,
+					Path:    pa.Path,
+					Service: pa.Backend.ServiceName,
+					Port:    servicePort(svcs, i.ObjectMeta.Namespace, pa.Backend),
+					Canary:  isCanary(i),
+				}
+				c.Reason = e.lost(c, problems)
+
+				e.Candidates = append(e.Candidates, c)
+			}
+		}
+	}
+
+	return e
+}
+
+// Returns the service port a backend is routed to, as it is written in the ingress when the service
+// doesn't have it or hasn't been loaded.
+func servicePort(svcs *Services, ns string, backend extensions.IngressBackend) string {
+	if svcs != nil {
+		port, err := svcs.ResolvePort(MergeNameNameSpace(ns, backend.ServiceName), backend.ServicePort)
+		if err == nil && port > 0 {
+			return strconv.Itoa(port)
+		}
+	}
+	return backend.ServicePort.String()
+}
+
+// Fills in the location of the server which serves the path.
+func (e *Explanation) explainServer(b Backend, server Server) {
+	if server.RedirectTo != "" {
+		e.Notes = append(e.Notes, fmt.Sprintf("Requests are redirected to %s", server.RedirectTo))
+		return
+	}
+	if server.SSLCert != nil && server.SSLRedirect {
+		e.Notes = append(e.Notes, "Plain HTTP requests are redirected to HTTPS")
+	}
+
+	n := matchLocation(server, e.Path)
+	if n < 0 {
+		e.Notes = append(e.Notes, "No location matches the path, nginx answers with a 404")
+		return
+	}
+
+	l := server.Locations[n]
+	e.Location = &l
+
+	if l.Redirect != nil {
+		e.Notes = append(e.Notes, fmt.Sprintf("Requests are redirected to %s with a %d", l.Redirect.URL, l.Redirect.Code))
+		return
+	}
+
+	if backup, ok := b.Backups[l.Upstream]; ok {
+		e.Endpoints = b.Upstreams[backup.Primary]
+		e.BackupEndpoints = b.Upstreams[backup.Backup]
+	} else {
+		e.Endpoints = b.Upstreams[l.Upstream]
+	}
+
+	if len(e.Endpoints) == 0 {
+		e.Notes = append(e.Notes, "The upstream has no endpoints")
+	}
+	if l.Canary != nil {
+		e.Notes = append(e.Notes, fmt.Sprintf("The canary ingress %s receives %s", l.Canary.Ingress, describeCanary(*l.Canary)))
+	}
+	if l.Mirror != nil {
+		e.Notes = append(e.Notes, fmt.Sprintf("%d%% of the requests are mirrored to %s", l.Mirror.Sample, l.Mirror.Upstream))
+	}
+}
+
+// Returns why a candidate does not serve the request, empty when it does.
+func (e Explanation) lost(c Candidate, problems []Problem) string {
+	l := e.Location
+
+	if l != nil && l.Path == c.Path && c.Host == e.Server {
+		if !c.Canary && l.Ingress == c.Ingress {
+			return ""
+		}
+		if c.Canary && l.Canary != nil && l.Canary.Ingress == c.Ingress {
+			return ""
+		}
+	}
+
+	for _, p := range problems {
+		if !p.Skipped || p.Namespace+"/"+p.Name != c.Ingress {
+			continue
+		}
+		if (p.Host == "" || p.Host == c.Host) && (p.Path == "" || p.Path == c.Path) {
+			return p.Message
+		}
+	}
+
+	switch {
+	case c.Host != e.Server:
+		return fmt.Sprintf("The host is served by %s", e.Server)
+	case l == nil:
+		return "The path is not routed"
+	case len(c.Path) < len(l.Path):
+		return fmt.Sprintf("The longer path %s is used", l.Path)
+	default:
+		return fmt.Sprintf("The path is routed by %s", l.Ingress)
+	}
+}
+
+// Returns the server for a host the way nginx picks it: the exact name, then the longest wildcard.
+func matchServer(b Backend, host string) (string, bool) {
+	if _, ok := b.Servers[host]; ok {
+		return host, true
+	}
+
+	var match string
+
+	for name := range b.Servers {
+		if strings.HasPrefix(name, "*.") && strings.HasSuffix(host, name[1:]) && len(name) > len(match) {
+			match = name
+		}
+	}
+
+	return match, match != ""
+}
+
+// Returns the index of the location with the longest prefix of the path, -1 if there is none.
+func matchLocation(s Server, path string) int {
+	match := -1
+
+	for n, l := range s.Locations {
+		if strings.HasPrefix(path, l.Path) && (match < 0 || len(l.Path) > len(s.Locations[match].Path)) {
+			match = n
+		}
+	}
+
+	return match
+}
+
+// Describes which requests a canary receives.
+func describeCanary(c Canary) string {
+	s := fmt.Sprintf("%d%% of the requests", c.Weight)
+	if c.Header != "" {
+		s += fmt.Sprintf(", and those with the %s header", c.Header)
+	}
+	if c.Cookie != "" {
+		s += fmt.Sprintf(", and those with the %s cookie", c.Cookie)
+	}
+	return s
+}
+
+// Hosts are matched case insensitively and without the port.
+func hostWithoutPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+func (e Explanation) String() string {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "Request:   %s%s\n", e.Host, e.Path)
+
+	if e.Server == "" {
+		fmt.Fprintf(&b, "Server:    default\n")
+	} else {
+		fmt.Fprintf(&b, "Server:    %s\n", e.Server)
+	}
+
+	if l := e.Location; l != nil {
+		fmt.Fprintf(&b, "Location:  %s\n", l.Path)
+		fmt.Fprintf(&b, "Ingress:   %s\n", l.Ingress)
+
+		for _, c := range e.Candidates {
+			if c.Reason == "" && !c.Canary {
+				fmt.Fprintf(&b, "Rule:      %d\n", c.Rule)
+				fmt.Fprintf(&b, "Service:   %s:%s\n", c.Service, c.Port)
+			}
+		}
+
+		if l.Upstream != "" {
+			fmt.Fprintf(&b, "Upstream:  %s\n", l.Upstream)
+			fmt.Fprintf(&b, "Endpoints: %s\n", strings.Join(e.Endpoints, ", "))
+		}
+		if len(e.BackupEndpoints) > 0 {
+			fmt.Fprintf(&b, "Backup:    %s\n", strings.Join(e.BackupEndpoints, ", "))
+		}
+	}
+
+	for _, n := range e.Notes {
+		fmt.Fprintf(&b, "Note:      %s\n", n)
+	}
+
+	if len(e.Candidates) > 0 {
+		fmt.Fprintf(&b, "\nCandidates:\n")
+	}
+	for _, c := range e.Candidates {
+		state := "serves the request"
+		if c.Canary {
+			state = "canary"
+		}
+		if c.Reason != "" {
+			state = c.Reason
+		}
+		fmt.Fprintf(&b, "  %s rule %d %s%s -> %s:%s: %s\n", c.Ingress, c.Rule, c.Host, c.Path, c.Service, c.Port, state)
+	}
+
+	return b.String()
+}
+
+// Runs the explain command, returning the exit code.
+func explain() int {
+	cfg, err := flagConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	m, err := LoadManifests(*cliExplainFiles)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Without any manifests the request is explained for the cluster.
+	if len(*cliExplainFiles) == 0 {
+		c, err := client.New(&client.Config{
+			Host: *cliApi,
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		m, err = LoadClusterManifests(c, m)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load the cluster: %v\n", err)
+			return 1
+		}
+	}
+
+	bu := manifestBuilder(cfg, m)
+	if len(m.Services) == 0 {
+		bu.Services.List = referencedServices(m.Ingresses)
+	}
+	b := bu.Build(m.Ingresses)
+
+	// The problems found while building are part of the explanation.
+	fmt.Fprint(os.Stdout, Explain(m.Ingresses, b, bu.Problems, bu.Services, *cliExplainHost, *cliExplainPath))
+
+	return 0
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+func TestExplain(t *testing.T) {
+	m, err := LoadManifests([]string{"testdata/ingresses.yaml", "testdata/lint.yaml", "testdata/services.yaml", "testdata/endpoints.yaml"})
+	assert.Nil(t, err)
+
+	bu := manifestBuilder(Config{}, m)
+	b := bu.Build(m.Ingresses)
+
+	e := Explain(m.Ingresses, b, bu.Problems, bu.Services, "Example.com:8080", "/blog/post")
+	assert.Equal(t, "example.com", e.Server, "Hosts are matched without the port")
+	assert.Equal(t, "/", e.Location.Path)
+	assert.Equal(t, "default/web", e.Location.Ingress)
+	assert.Equal(t, []string{"10.0.0.1:8080", "10.0.0.2:8080"}, e.Endpoints)
+	assert.Equal(t, []Candidate{
+		Candidate{Ingress: "default/web", Host: "example.com", Path: "/", Service: "web", Port: "80"},
+		Candidate{Ingress: "default/web-canary", Host: "example.com", Path: "/", Service: "web-v2", Port: "80", Canary: true}, // Routed to the port named http
+		Candidate{Ingress: "default/blog", Host: "example.com", Path: "/", Service: "blog", Port: "80", Reason: "Skipping example.com/ for ingress default/blog: the path is already routed by default/web"},
+		Candidate{Ingress: "default/blog", Host: "example.com", Path: "/blog", Service: "blog", Port: "80", Reason: "Skipping example.com/blog for ingress default/blog: Cannot find the service: default-blog"},
+	}, e.Candidates)
+
+	e = Explain(m.Ingresses, b, bu.Problems, bu.Services, "unknown.com", "/")
+	assert.Empty(t, e.Server, "The default server answers unknown hosts")
+	assert.Nil(t, e.Location)
+}
+
+func TestExplainLongestPath(t *testing.T) {
+	svcs := map[string][]string{
+		"default-web": []string{"1.2.3.4:80"},
+		"default-api": []string{"1.2.3.5:80"},
+	}
+
+	ings := []extensions.Ingress{
+		testIngress("default", "web", "*.example.com", "/", "web", nil),
+		testIngress("default", "api", "*.example.com", "/api", "api", nil),
+		testIngress("default", "v1", "*.example.com", "/api/v1", "missing", nil),
+	}
+
+	bu := &Builder{Services: &Services{List: svcs}}
+	b := bu.Build(ings)
+
+	e := Explain(ings, b, bu.Problems, bu.Services, "www.example.com", "/api/v1/users")
+	assert.Equal(t, "*.example.com", e.Server, "Wildcards match when there is no exact server")
+	assert.Equal(t, "default/api", e.Location.Ingress)
+	assert.Equal(t, []string{"1.2.3.5:80"}, e.Endpoints)
+	assert.Equal(t, "The longer path /api is used", e.Candidates[0].Reason)
+	assert.Equal(t, "", e.Candidates[1].Reason)
+	assert.Equal(t, "Skipping *.example.com/api/v1 for ingress default/v1: Cannot find the service: default-missing", e.Candidates[2].Reason)
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// Builder translates ingresses into the nginx backend.
+type Builder struct {
+	Config   Config
+	Services *Services
+	Secrets  *Secrets
+
+	// External ports mapped to namespace/service:port for the stream module.
+	TCPServices map[string]string
+	UDPServices map[string]string
+
+	// Problems with the ingresses found by the last build.
+	Problems []Problem
+
+	// Don't log the problems, eg. when validating an ingress for the webhook.
+	Quiet bool
+}
+
+// Logs a message about the build unless the builder is quiet.
+func (bu *Builder) logf(format string, args ...interface{}) {
+	if !bu.Quiet {
+		fmt.Printf(format, args...)
+	}
+}
+
+// Builds the nginx backend for a list of ingresses.
+func (bu *Builder) Build(ings []extensions.Ingress) Backend {
+	b := Backend{
+		Servers:    make(map[string]Server),
+		Upstreams:  make(map[string][]string),
+		RateLimits: make(map[string]RateLimit),
+		Canaries:   make(map[string]Canary),
+		Mirrors:    make(map[string]Mirror),
+		CORS:       make(map[string]CORS),
+		Backups:    make(map[string]BackupUpstream),
+		TCP:        bu.streams(bu.TCPServices),
+		UDP:        bu.streams(bu.UDPServices),
+	}
+
+	bu.Problems = nil
+
+	// Canaries are attached to the locations of the primary ingresses so those need to be built first.
+	var canaries []extensions.Ingress
+
+	for _, i := range ings {
+		bu.checkAnnotations(i)
+
+		if isCanary(i) {
+			canaries = append(canaries, i)
+			continue
+		}
+		bu.addIngress(&b, i)
+	}
+
+	for _, i := range canaries {
+		bu.addCanary(&b, i)
+	}
+
+	bu.addWWWRedirects(&b)
+
+	return b
+}
+
+// Adds the servers and locations of an ingress to the backend.
+func (bu *Builder) addIngress(b *Backend, i extensions.Ingress) {
+	rl, err := parseRateLimit(i)
+	if err != nil {
+		bu.report(i, SeverityError, "annotation", "Ignoring rate limits for ingress %s/%s: %s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, err)
+	} else if rl != nil {
+		b.RateLimits[rl.Zone] = *rl
+	}
+
+	ssl, err := parseSSLPolicy(i, bu.Config)
+	if err != nil {
+		bu.report(i, SeverityError, "annotation", "Using the default HTTPS settings for ingress %s/%s: %s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, err)
+		ssl, _ = parseSSLPolicy(extensions.Ingress{}, bu.Config)
+	}
+
+	timeouts, err := parseTimeouts(i, bu.Config)
+	if err != nil {
+		bu.report(i, SeverityError, "annotation", "Using the default timeouts for ingress %s/%s: %s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, err)
+	}
+
+	protocol, err := parseBackendProtocol(i)
+	if err != nil {
+		bu.skip(i, "", "", "annotation", "Skipping ingress %s/%s: %s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, err)
+		return
+	}
+
+	upstreamTLS, err := bu.upstreamTLS(i)
+	if err != nil {
+		bu.skip(i, "", "", "annotation", "Skipping ingress %s/%s: %s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, err)
+		return
+	}
+
+	mirror, err := parseMirror(i)
+	if err != nil {
+		bu.report(i, SeverityError, "annotation", "Not mirroring ingress %s/%s: %s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, err)
+	}
+
+	customErrors, err := bu.customErrors(b, i)
+	if err != nil {
+		bu.report(i, SeverityError, "annotation", "Using the default error pages for ingress %s/%s: %s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, err)
+	}
+
+	redirect, err := parseRedirect(i)
+	if err != nil {
+		bu.skip(i, "", "", "annotation", "Skipping ingress %s/%s: %s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, err)
+		return
+	}
+
+	fromToWWW, err := annotationBool(i, "from-to-www-redirect", false)
+	if err != nil {
+		bu.report(i, SeverityError, "annotation", "Not redirecting www for ingress %s/%s: %s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, err)
+	}
+
+	cors, err := parseCORS(i)
+	if err != nil {
+		bu.report(i, SeverityError, "annotation", "Not enabling CORS for ingress %s/%s: %s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, err)
+	} else if cors != nil {
+		b.CORS[cors.Name] = *cors
+
+		// Error responses need the CORS headers as well.
+		if customErrors != nil {
+			customErrors.CORS = cors
+		}
+	}
+
+	nextUpstream, err := parseNextUpstream(i, bu.Config)
+	if err != nil {
+		bu.report(i, SeverityError, "annotation", "Using the default retry policy for ingress %s/%s: %s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, err)
+	}
+
+	cert := bu.certificate(i)
+
+	// Build a our listeners based on the ingress rules.
+	for _, r := range i.Spec.Rules {
+		if r.HTTP == nil {
+			continue
+		}
+
+		if err := validHost(r.Host); err != nil {
+			bu.skip(i, r.Host, "", "host", "Skipping %s for ingress %s/%s: %s", r.Host, i.ObjectMeta.Namespace, i.ObjectMeta.Name, err)
+			continue
+		}
+
+		// Ingresses can share a host, the first one to define it decides the server settings.
+		server, ok := b.Servers[r.Host]
+		if !ok {
+			server = Server{
+				SSLCert:          cert,
+				SSLRedirect:      ssl.Redirect,
+				ForceSSLRedirect: ssl.ForceRedirect,
+				HSTS:             ssl.HSTS,
+				ErrorPages:       make(map[string]ErrorPage),
+				FromToWWW:        fromToWWW,
+			}
+		}
+
+		var locations []Location
+
+		for _, pa := range r.HTTP.Paths {
+			if !bu.checkPath(i, r.Host, pa.Path) {
+				continue
+			}
+
+			if n := server.location(pa.Path); n >= 0 {
+				bu.skip(i, r.Host, pa.Path, "conflict", "Skipping %s%s for ingress %s/%s: the path is already routed by %s", r.Host, pa.Path, i.ObjectMeta.Namespace, i.ObjectMeta.Name, server.Locations[n].Ingress)
+				continue
+			}
+
+			// Redirects don't need a backend to exist.
+			if redirect != nil {
+				locations = append(locations, Location{
+					Path:     pa.Path,
+					Ingress:  i.ObjectMeta.Namespace + "/" + i.ObjectMeta.Name,
+					Redirect: redirect,
+				})
+				continue
+			}
+
+			port, err := bu.Services.ResolvePort(MergeNameNameSpace(i.ObjectMeta.Namespace, pa.Backend.ServiceName), pa.Backend.ServicePort)
+			if err != nil {
+				bu.skip(i, r.Host, pa.Path, "port", "Skipping %s%s for ingress %s/%s: %s", r.Host, pa.Path, i.ObjectMeta.Namespace, i.ObjectMeta.Name, err)
+				continue
+			}
+
+			// Get the upstream for the backend of this rule.
+			name, err := bu.upstream(b, i, pa.Backend.ServiceName, port)
+			if err != nil {
+				bu.skip(i, r.Host, pa.Path, "service", "Skipping %s%s for ingress %s/%s: %s", r.Host, pa.Path, i.ObjectMeta.Namespace, i.ObjectMeta.Name, err)
+				continue
+			}
+
+			// Add this to our list of paths to implement in Nginx.
+			l := Location{
+				Path:         pa.Path,
+				Upstream:     name,
+				Ingress:      i.ObjectMeta.Namespace + "/" + i.ObjectMeta.Name,
+				RateLimit:    rl,
+				Timeouts:     timeouts,
+				Protocol:     protocol,
+				UpstreamTLS:  upstreamTLS.forService(i.ObjectMeta.Namespace, pa.Backend.ServiceName),
+				Mirror:       bu.mirror(b, i, mirror, r.Host, pa.Path),
+				CustomErrors: customErrors,
+				CORS:         cors,
+				NextUpstream: nextUpstream,
+			}
+			locations = append(locations, l)
+
+			// Each server needs the internal locations for the error pages it intercepts.
+			if customErrors != nil {
+				for _, code := range customErrors.Codes {
+					server.ErrorPages[customErrors.Location(code)] = ErrorPage{
+						Code:     code,
+						Upstream: customErrors.Upstream,
+						CORS:     customErrors.CORS,
+					}
+				}
+			}
+		}
+
+		// Add our list of generated locations to the nginx backend. These have been verified
+		// as having a backend so this is a safe operation.
+		if len(locations) > 0 {
+			server.Locations = append(server.Locations, locations...)
+			server.HTTP2 = server.HTTP2 || protocol == ProtocolGRPC || protocol == ProtocolGRPCS
+			if server.SSLCert == nil {
+				server.SSLCert = cert
+			}
+			b.Servers[r.Host] = server
+		}
+	}
+}
+
+// Looks up the certificate referenced by an ingress, nil if it does not have one.
+func (bu *Builder) certificate(i extensions.Ingress) *SSLCert {
+	secret, ok := annotation(i, "tls-secret")
+	if !ok || bu.Secrets == nil {
+		return nil
+	}
+
+	cert, err := bu.Secrets.Get(MergeNameNameSpace(i.ObjectMeta.Namespace, secret))
+	if err != nil || cert.PemFile == "" {
+		bu.report(i, SeverityError, "secret", "Serving ingress %s/%s without TLS, the certificate %s is not available", i.ObjectMeta.Namespace, i.ObjectMeta.Name, secret)
+		return nil
+	}
+
+	return &cert
+}
+
+// Loads the settings used to verify TLS backends, nil when the backend is not verified.
+func (bu *Builder) upstreamTLS(i extensions.Ingress) (*UpstreamTLS, error) {
+	verify, err := annotationBool(i, "proxy-ssl-verify", false)
+	if err != nil {
+		return nil, err
+	}
+
+	name, _ := annotation(i, "proxy-ssl-name")
+	if !verify && name == "" {
+		return nil, nil
+	}
+
+	t := &UpstreamTLS{
+		Verify: verify,
+		Name:   name,
+	}
+
+	if !verify {
+		return t, nil
+	}
+
+	// We refuse to route to the backend rather than silently skip verification.
+	secret, ok := annotation(i, "proxy-ssl-secret")
+	if !ok {
+		return nil, errors.New("Verifying the backend requires the proxy-ssl-secret annotation")
+	}
+	if bu.Secrets == nil {
+		return nil, errors.New(fmt.Sprintf("The certificate authority %s is not available", secret))
+	}
+
+	cert, err := bu.Secrets.Get(MergeNameNameSpace(i.ObjectMeta.Namespace, secret))
+	if err != nil || cert.CAFile == "" {
+		return nil, errors.New(fmt.Sprintf("The certificate authority %s is not available", secret))
+	}
+
+	t.CAFile = cert.CAFile
+	t.Checksum = cert.Checksum
+
+	return t, nil
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util"
+)
+
+// Helper to build an ingress routing a host and path to a service.
+func testIngress(ns, name, host, path, svc string, annotations map[string]string) extensions.Ingress {
+	return extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Namespace:   ns,
+			Name:        name,
+			Annotations: annotations,
+		},
+		Spec: extensions.IngressSpec{
+			Rules: []extensions.IngressRule{
+				extensions.IngressRule{
+					Host: host,
+					IngressRuleValue: extensions.IngressRuleValue{
+						HTTP: &extensions.HTTPIngressRuleValue{
+							Paths: []extensions.HTTPIngressPath{
+								extensions.HTTPIngressPath{
+									Path:    path,
+									Backend: extensions.IngressBackend{ServiceName: svc},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// Helper to build a backend from a list of ingresses.
+func testBuild(c Config, svcs map[string][]string, certs map[string]SSLCert, ings ...extensions.Ingress) Backend {
+	bu := &Builder{
+		Config:   c,
+		Services: &Services{List: svcs},
+		Secrets:  &Secrets{List: certs},
+	}
+	return bu.Build(ings)
+}
+
+// Helper to render the configuration for a backend.
+func testRender(t *testing.T, b Backend) string {
+	n, err := NewNginx(Config{Port: "80", SSLPort: "443"})
+	assert.Nil(t, err)
+	n.SetBackend(b)
+
+	var buf bytes.Buffer
+	assert.Nil(t, n.Render(&buf))
+	return buf.String()
+}
+
+func TestBuildBackend(t *testing.T) {
+	svcs := map[string][]string{
+		"default-web": []string{"1.2.3.4:80"},
+	}
+
+	b := testBuild(Config{}, svcs, nil,
+		testIngress("default", "web", "example.com", "/", "web", nil),
+		testIngress("default", "missing", "missing.com", "/", "missing", nil),
+	)
+	assert.Equal(t, []Location{Location{Path: "/", Upstream: "default-web", Ingress: "default/web", Protocol: ProtocolHTTP}}, b.Servers["example.com"].Locations)
+	assert.Equal(t, []string{"1.2.3.4:80"}, b.Upstreams["default-web"])
+	_, ok := b.Servers["missing.com"]
+	assert.False(t, ok, "Ingresses without a service are not routed")
+}
+
+func TestRateLimit(t *testing.T) {
+	svcs := map[string][]string{
+		"default-web": []string{"1.2.3.4:80"},
+	}
+
+	b := testBuild(Config{}, svcs, nil,
+		testIngress("default", "web", "example.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/limit-rps":         "10",
+			"ingress.kubernetes.io/limit-burst":       "20",
+			"ingress.kubernetes.io/limit-connections": "5",
+			"ingress.kubernetes.io/limit-whitelist":   "10.0.0.0/8, 192.168.0.1",
+		}),
+	)
+	zone := zoneName("default", "web")
+	assert.Equal(t, zone, b.Servers["example.com"].Locations[0].RateLimit.Zone)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.0.1"}, b.RateLimits[zone].Whitelist)
+
+	cfg := testRender(t, b)
+	assert.Contains(t, cfg, "limit_req_zone $limit_"+zone+"_key zone="+zone+"_rps:10m rate=10r/s;")
+	assert.Contains(t, cfg, "limit_conn_zone $limit_"+zone+"_key zone="+zone+"_conn:10m;")
+	assert.Contains(t, cfg, "limit_req zone="+zone+"_rps burst=20 nodelay;")
+	assert.Contains(t, cfg, "limit_conn "+zone+"_conn 5;")
+	assert.Contains(t, cfg, "10.0.0.0/8 1;")
+	assert.NotContains(t, cfg, "_rpm")
+}
+
+func TestRateLimitInvalid(t *testing.T) {
+	i := testIngress("default", "web", "example.com", "/", "web", map[string]string{
+		"ingress.kubernetes.io/limit-rps": "lots",
+	})
+	_, err := parseRateLimit(i)
+	assert.NotNil(t, err)
+
+	i = testIngress("default", "web", "example.com", "/", "web", map[string]string{
+		"ingress.kubernetes.io/limit-rps":       "1",
+		"ingress.kubernetes.io/limit-whitelist": "10.0.0.0/33",
+	})
+	_, err = parseRateLimit(i)
+	assert.NotNil(t, err)
+}
+
+func TestSSLRedirect(t *testing.T) {
+	var (
+		svcs = map[string][]string{
+			"default-web": []string{"1.2.3.4:80"},
+		}
+		certs = map[string]SSLCert{
+			"default-web-tls": SSLCert{PemFile: "/etc/nginx/ssl/default-web-tls.pem"},
+		}
+		cfg = Config{
+			SSLRedirect: true,
+			HSTSEnabled: true,
+			HSTS:        HSTS{MaxAge: 300, IncludeSubdomains: true},
+		}
+	)
+
+	b := testBuild(cfg, svcs, certs,
+		testIngress("default", "web", "example.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/tls-secret":   "web-tls",
+			"ingress.kubernetes.io/hsts-preload": "true",
+		}),
+		testIngress("default", "plain", "plain.com", "/", "web", nil),
+		testIngress("default", "upstream", "upstream.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/force-ssl-redirect": "true",
+			"ingress.kubernetes.io/hsts":               "false",
+		}),
+	)
+	assert.True(t, b.Servers["example.com"].SSLRedirect)
+	assert.Nil(t, b.Servers["plain.com"].SSLCert)
+	assert.Nil(t, b.Servers["upstream.com"].HSTS)
+
+	out := testRender(t, b)
+	assert.Contains(t, out, "return 308 https://$host$request_uri;")
+	assert.Contains(t, out, "ssl_certificate     /etc/nginx/ssl/default-web-tls.pem;")
+	assert.Contains(t, out, `add_header Strict-Transport-Security "max-age=300; includeSubDomains; preload" always;`)
+	assert.Contains(t, out, `if ($http_x_forwarded_proto = "http") {`)
+	assert.Equal(t, 1, strings.Count(out, "Strict-Transport-Security"), "HSTS is only sent for HTTPS hosts")
+}
+
+func TestWebSocket(t *testing.T) {
+	svcs := map[string][]string{
+		"default-ws": []string{"1.2.3.4:80"},
+	}
+
+	b := testBuild(Config{Timeouts: Timeouts{Read: 60, Send: 60}}, svcs, nil,
+		testIngress("default", "ws", "ws.com", "/", "ws", map[string]string{
+			"ingress.kubernetes.io/proxy-read-timeout": "3600",
+		}),
+	)
+	assert.Equal(t, Timeouts{Read: 3600, Send: 60}, b.Servers["ws.com"].Locations[0].Timeouts)
+
+	out := testRender(t, b)
+	assert.Contains(t, out, "map $http_upgrade $connection_upgrade {")
+	assert.Contains(t, out, "proxy_http_version 1.1;")
+	assert.Contains(t, out, "proxy_set_header Connection $connection_upgrade;")
+	assert.Contains(t, out, "proxy_read_timeout 3600s;")
+	assert.Contains(t, out, "proxy_send_timeout 60s;")
+}
+
+func TestBackendProtocol(t *testing.T) {
+	var (
+		svcs = map[string][]string{
+			"default-grpc": []string{"1.2.3.4:80"},
+			"default-tls":  []string{"1.2.3.5:443"},
+		}
+		certs = map[string]SSLCert{
+			"default-ca": SSLCert{CAFile: "/etc/nginx/ssl/default-ca-ca.crt"},
+		}
+	)
+
+	b := testBuild(Config{}, svcs, certs,
+		testIngress("default", "grpc", "grpc.com", "/", "grpc", map[string]string{
+			"ingress.kubernetes.io/backend-protocol": "grpc",
+		}),
+		testIngress("default", "tls", "tls.com", "/", "tls", map[string]string{
+			"ingress.kubernetes.io/backend-protocol": "HTTPS",
+			"ingress.kubernetes.io/proxy-ssl-verify": "true",
+			"ingress.kubernetes.io/proxy-ssl-secret": "ca",
+			"ingress.kubernetes.io/proxy-ssl-name":   "tls.internal",
+		}),
+		testIngress("default", "tls-default-name", "tls-default-name.com", "/", "tls", map[string]string{
+			"ingress.kubernetes.io/backend-protocol": "HTTPS",
+			"ingress.kubernetes.io/proxy-ssl-verify": "true",
+			"ingress.kubernetes.io/proxy-ssl-secret": "ca",
+		}),
+		testIngress("default", "unverified", "unverified.com", "/", "tls", map[string]string{
+			"ingress.kubernetes.io/backend-protocol": "HTTPS",
+			"ingress.kubernetes.io/proxy-ssl-verify": "true",
+			"ingress.kubernetes.io/proxy-ssl-secret": "missing",
+		}),
+	)
+	assert.True(t, b.Servers["grpc.com"].HTTP2)
+	assert.False(t, b.Servers["tls.com"].HTTP2)
+	_, ok := b.Servers["unverified.com"]
+	assert.False(t, ok, "Backends which cannot be verified are not routed")
+
+	out := testRender(t, b)
+	assert.Contains(t, out, "http2 on;")
+	assert.Contains(t, out, "grpc_pass grpc://default-grpc;")
+	assert.Contains(t, out, "proxy_pass https://default-tls;")
+	assert.Contains(t, out, "proxy_ssl_trusted_certificate /etc/nginx/ssl/default-ca-ca.crt;")
+	assert.Contains(t, out, "proxy_ssl_name tls.internal;")
+	assert.Contains(t, out, "proxy_ssl_name tls.default.svc;", "The name defaults to the DNS name of the service")
+}
+
+func TestStreams(t *testing.T) {
+	bu := &Builder{
+		Services: &Services{
+			Ports: map[string]map[int][]string{
+				"db-postgres": map[int][]string{5432: []string{"1.2.3.4:5432"}},
+				"kube-dns":    map[int][]string{53: []string{"1.2.3.5:53"}},
+			},
+		},
+		TCPServices: map[string]string{
+			"5432": "db/postgres:5432",
+			"6379": "db/redis:6379",
+			"abc":  "db/postgres:5432",
+		},
+		UDPServices: map[string]string{
+			"53": "kube/dns:53",
+		},
+	}
+
+	b := bu.Build(nil)
+	assert.Equal(t, map[string]Stream{"5432": Stream{Service: "db/postgres:5432", Addresses: []string{"1.2.3.4:5432"}}}, b.TCP)
+	assert.Equal(t, []string{"1.2.3.5:53"}, b.UDP["53"].Addresses)
+
+	out := testRender(t, b)
+	assert.Contains(t, out, "stream {")
+	assert.Contains(t, out, "proxy_pass tcp_5432;")
+	assert.Contains(t, out, "listen 53 udp;")
+}
+
+func TestServicePort(t *testing.T) {
+	bu := &Builder{
+		Services: &Services{
+			List: map[string][]string{"default-web": []string{"1.2.3.4:8080"}},
+			Ports: map[string]map[int][]string{
+				"default-web": map[int][]string{80: []string{"1.2.3.4:8080"}, 9000: []string{"1.2.3.4:9000"}},
+			},
+			ServicePorts: map[string][]api.ServicePort{
+				"default-web": []api.ServicePort{
+					api.ServicePort{Name: "http", Port: 80},
+					api.ServicePort{Name: "admin", Port: 9000},
+				},
+			},
+		},
+	}
+
+	web := testIngress("default", "web", "example.com", "/", "web", nil)
+	web.Spec.Rules[0].HTTP.Paths[0].Backend.ServicePort = util.NewIntOrStringFromInt(80)
+	admin := testIngress("default", "admin", "admin.example.com", "/", "web", nil)
+	admin.Spec.Rules[0].HTTP.Paths[0].Backend.ServicePort = util.NewIntOrStringFromString("admin")
+	missing := testIngress("default", "missing", "missing.example.com", "/", "web", nil)
+	missing.Spec.Rules[0].HTTP.Paths[0].Backend.ServicePort = util.NewIntOrStringFromInt(8443)
+
+	b := bu.Build([]extensions.Ingress{web, admin, missing})
+	assert.Equal(t, "default-web", b.Servers["example.com"].Locations[0].Upstream)
+	assert.Equal(t, "default-web-9000", b.Servers["admin.example.com"].Locations[0].Upstream, "Ports are resolved by name")
+	assert.Equal(t, []string{"1.2.3.4:9000"}, b.Upstreams["default-web-9000"])
+	assert.NotContains(t, b.Servers, "missing.example.com", "Ports the service doesn't have are not routed")
+	assert.Len(t, bu.Problems, 1)
+	assert.Equal(t, "port", bu.Problems[0].Rule)
+	assert.Equal(t, "Skipping missing.example.com/ for ingress default/missing: Cannot find the service port: default-web:8443", bu.Problems[0].Message)
+}
+
+func TestSharedHost(t *testing.T) {
+	svcs := map[string][]string{
+		"default-web": []string{"1.2.3.4:80"},
+		"default-api": []string{"1.2.3.5:80"},
+	}
+
+	b := testBuild(Config{}, svcs, nil,
+		testIngress("default", "web", "example.com", "/", "web", nil),
+		testIngress("default", "api", "example.com", "/api", "api", nil),
+		testIngress("default", "dupe", "example.com", "/api", "web", nil),
+	)
+	assert.Len(t, b.Servers["example.com"].Locations, 2, "Ingresses sharing a host are merged")
+	assert.Equal(t, "default-api", b.Servers["example.com"].Locations[1].Upstream)
+}
+
+func TestCanary(t *testing.T) {
+	svcs := map[string][]string{
+		"default-web":    []string{"1.2.3.4:80"},
+		"default-web-v2": []string{"1.2.3.5:80"},
+	}
+
+	b := testBuild(Config{}, svcs, nil,
+		testIngress("default", "web-canary", "example.com", "/", "web-v2", map[string]string{
+			"ingress.kubernetes.io/canary":           "true",
+			"ingress.kubernetes.io/canary-weight":    "5",
+			"ingress.kubernetes.io/canary-by-header": "X-Canary",
+			"ingress.kubernetes.io/canary-by-cookie": "canary",
+		}),
+		testIngress("default", "web", "example.com", "/", "web", nil),
+	)
+
+	l := b.Servers["example.com"].Locations
+	assert.Len(t, l, 1, "The canary does not replace the primary location")
+	assert.Equal(t, "default-web", l[0].Upstream)
+	assert.Equal(t, "default-web-v2", l[0].Canary.Upstream)
+	assert.Equal(t, []string{"1.2.3.5:80"}, b.Upstreams["default-web-v2"])
+
+	c := l[0].Canary
+	out := testRender(t, b)
+	assert.Contains(t, out, "split_clients \"${remote_addr}${request_id}\" "+c.WeightVariable()+" {")
+	assert.Contains(t, out, "5% default-web-v2;")
+	assert.Contains(t, out, "map $http_x_canary "+c.HeaderVariable()+" {")
+	assert.Contains(t, out, "map $cookie_canary "+c.CookieVariable()+" {")
+	assert.Contains(t, out, "default "+c.CookieVariable()+";")
+	assert.Contains(t, out, "proxy_pass http://"+c.HeaderVariable()+";")
+}
+
+func TestCanaryWithoutPrimary(t *testing.T) {
+	svcs := map[string][]string{
+		"default-web-v2": []string{"1.2.3.5:80"},
+	}
+
+	b := testBuild(Config{}, svcs, nil,
+		testIngress("default", "web-canary", "example.com", "/", "web-v2", map[string]string{
+			"ingress.kubernetes.io/canary":        "true",
+			"ingress.kubernetes.io/canary-weight": "50",
+		}),
+	)
+	assert.Empty(t, b.Servers)
+	assert.Empty(t, b.Canaries)
+}
+
+func TestMirror(t *testing.T) {
+	svcs := map[string][]string{
+		"default-web":    []string{"1.2.3.4:80"},
+		"default-shadow": []string{"1.2.3.5:80"},
+	}
+
+	b := testBuild(Config{}, svcs, nil,
+		testIngress("default", "web", "example.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/mirror-target":       "shadow",
+			"ingress.kubernetes.io/mirror-sample":       "10",
+			"ingress.kubernetes.io/mirror-request-body": "false",
+		}),
+		testIngress("default", "missing", "missing.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/mirror-target": "missing",
+		}),
+	)
+
+	m := b.Servers["example.com"].Locations[0].Mirror
+	assert.Equal(t, "default-shadow", m.Upstream)
+	assert.Equal(t, []string{"1.2.3.5:80"}, b.Upstreams["default-shadow"])
+	assert.Nil(t, b.Servers["missing.com"].Locations[0].Mirror, "Locations are served when the mirror is missing")
+
+	out := testRender(t, b)
+	assert.Contains(t, out, "mirror "+m.Path()+";")
+	assert.Contains(t, out, "mirror_request_body off;")
+	assert.Contains(t, out, "location = "+m.Path()+" {")
+	assert.Contains(t, out, "10% 1;")
+	assert.Contains(t, out, "proxy_pass http://default-shadow$request_uri;")
+}
+
+func TestCustomErrors(t *testing.T) {
+	svcs := map[string][]string{
+		"default-web":    []string{"1.2.3.4:80"},
+		"default-errors": []string{"1.2.3.5:80"},
+	}
+
+	b := testBuild(Config{CustomHTTPErrors: []int{502}, DefaultBackendPort: "8181"}, svcs, nil,
+		testIngress("default", "web", "example.com", "/", "web", nil),
+		testIngress("default", "custom", "custom.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/custom-http-errors": "404,503",
+			"ingress.kubernetes.io/default-backend":    "errors",
+		}),
+	)
+	assert.Equal(t, &CustomErrors{Codes: []int{502}, Upstream: defaultBackendUpstream}, b.Servers["example.com"].Locations[0].CustomErrors)
+	assert.Equal(t, &CustomErrors{Codes: []int{404, 503}, Upstream: "default-errors"}, b.Servers["custom.com"].Locations[0].CustomErrors)
+
+	n, err := NewNginx(Config{Port: "80", DefaultBackendPort: "8181"})
+	assert.Nil(t, err)
+	n.SetBackend(b)
+
+	var buf bytes.Buffer
+	assert.Nil(t, n.Render(&buf))
+	out := buf.String()
+	assert.Contains(t, out, "listen      80 default_server;")
+	assert.Contains(t, out, "server 127.0.0.1:8181;")
+	assert.Contains(t, out, "proxy_intercept_errors on;")
+	assert.Contains(t, out, "error_page 502 = @custom_kube_ingress_default_backend_502;")
+	assert.Contains(t, out, "error_page 503 = @custom_default_errors_503;")
+	assert.Contains(t, out, "location @custom_default_errors_503 {")
+	assert.Contains(t, out, "proxy_set_header X-Code 503;")
+
+	// Without the built in backend only an error service can render the error pages.
+	b = testBuild(Config{}, svcs, nil,
+		testIngress("default", "web", "example.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/custom-http-errors": "404",
+		}),
+		testIngress("default", "custom", "custom.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/custom-http-errors": "404",
+			"ingress.kubernetes.io/default-backend":    "errors",
+		}),
+	)
+	assert.Nil(t, b.Servers["example.com"].Locations[0].CustomErrors)
+	assert.Equal(t, &CustomErrors{Codes: []int{404}, Upstream: "default-errors"}, b.Servers["custom.com"].Locations[0].CustomErrors)
+	assert.NotContains(t, testRender(t, b), defaultBackendUpstream)
+}
+
+func TestRedirect(t *testing.T) {
+	svcs := map[string][]string{
+		"default-web": []string{"1.2.3.4:80"},
+	}
+
+	b := testBuild(Config{}, svcs, nil,
+		testIngress("default", "old", "old.com", "/", "missing", map[string]string{
+			"ingress.kubernetes.io/permanent-redirect":      "https://new.com",
+			"ingress.kubernetes.io/permanent-redirect-code": "308",
+		}),
+		testIngress("default", "sale", "shop.com", "/sale", "missing", map[string]string{
+			"ingress.kubernetes.io/temporal-redirect": "https://shop.com/offers",
+		}),
+		testIngress("default", "web", "www.example.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/from-to-www-redirect": "true",
+		}),
+		testIngress("default", "invalid", "invalid.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/permanent-redirect":      "https://new.com",
+			"ingress.kubernetes.io/permanent-redirect-code": "302",
+		}),
+	)
+	assert.Equal(t, &Redirect{URL: "https://new.com", Code: 308}, b.Servers["old.com"].Locations[0].Redirect, "Redirects don't need a service")
+	assert.Equal(t, &Redirect{URL: "https://shop.com/offers", Code: 302}, b.Servers["shop.com"].Locations[0].Redirect)
+	assert.Equal(t, "www.example.com", b.Servers["example.com"].RedirectTo)
+	_, ok := b.Servers["invalid.com"]
+	assert.False(t, ok, "Invalid redirect codes are rejected")
+
+	out := testRender(t, b)
+	assert.Contains(t, out, "return 308 https://new.com;")
+	assert.Contains(t, out, "return 302 https://shop.com/offers;")
+	assert.Contains(t, out, "return 308 $scheme://www.example.com$request_uri;")
+}
+
+func TestCORS(t *testing.T) {
+	svcs := map[string][]string{
+		"default-web": []string{"1.2.3.4:80"},
+	}
+
+	b := testBuild(Config{}, svcs, nil,
+		testIngress("default", "web", "example.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/enable-cors":            "true",
+			"ingress.kubernetes.io/cors-allow-origin":      "https://app.com, ~^https://.+\\.example\\.com$",
+			"ingress.kubernetes.io/cors-allow-credentials": "true",
+			"ingress.kubernetes.io/cors-max-age":           "600",
+		}),
+		testIngress("default", "public", "public.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/enable-cors": "true",
+		}),
+		testIngress("default", "open", "open.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/enable-cors":            "true",
+			"ingress.kubernetes.io/cors-allow-credentials": "true",
+		}),
+	)
+
+	c := b.Servers["example.com"].Locations[0].CORS
+	assert.Equal(t, []string{"https://app.com", "~^https://.+\\.example\\.com$"}, c.Origins)
+	assert.True(t, c.Credentials)
+	assert.Equal(t, c.OriginVariable(), c.AllowOrigin())
+
+	// Any origin is allowed without credentials by default.
+	public := b.Servers["public.com"].Locations[0].CORS
+	assert.False(t, public.Credentials)
+	assert.Equal(t, "*", public.AllowOrigin())
+
+	// Credentials are refused when any origin is allowed.
+	assert.Nil(t, b.Servers["open.com"].Locations[0].CORS)
+
+	out := testRender(t, b)
+	assert.Contains(t, out, "map $http_origin "+c.OriginVariable()+" {")
+	assert.Contains(t, out, "\"https://app.com\" $http_origin;")
+	assert.NotContains(t, out, "default $http_origin;")
+	assert.Contains(t, out, "if ($request_method = OPTIONS) {")
+	assert.Contains(t, out, "add_header Access-Control-Max-Age 600 always;")
+	assert.Contains(t, out, "add_header Access-Control-Allow-Origin "+c.OriginVariable()+" always;")
+	assert.Contains(t, out, "add_header Access-Control-Allow-Origin * always;")
+}
+
+func TestCORSCustomErrors(t *testing.T) {
+	svcs := map[string][]string{
+		"default-web": []string{"1.2.3.4:80"},
+	}
+
+	b := testBuild(Config{CustomHTTPErrors: []int{502}, DefaultBackendPort: "8181"}, svcs, nil,
+		testIngress("default", "web", "example.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/enable-cors":            "true",
+			"ingress.kubernetes.io/cors-allow-origin":      "https://app.com",
+			"ingress.kubernetes.io/cors-allow-credentials": "true",
+		}),
+		testIngress("default", "plain", "example.com", "/plain", "web", nil),
+	)
+
+	locations := b.Servers["example.com"].Locations
+	c := locations[0].CORS
+	assert.Equal(t, c, locations[0].CustomErrors.CORS)
+	assert.Nil(t, locations[1].CustomErrors.CORS)
+
+	// The error pages of the CORS location get their own named location with the headers.
+	name := locations[0].CustomErrors.Location(502)
+	assert.Equal(t, "@custom_kube_ingress_default_backend_502_"+c.Name, name)
+	assert.Equal(t, c, b.Servers["example.com"].ErrorPages[name].CORS)
+	assert.Nil(t, b.Servers["example.com"].ErrorPages["@custom_kube_ingress_default_backend_502"].CORS)
+
+	out := testRender(t, b)
+	assert.Contains(t, out, "error_page 502 = "+name+";")
+	assert.Contains(t, out, "error_page 502 = @custom_kube_ingress_default_backend_502;")
+
+	page := out[strings.Index(out, "location "+name+" {"):]
+	page = page[:strings.Index(page, "}")]
+	assert.Contains(t, page, "add_header Access-Control-Allow-Origin "+c.OriginVariable()+" always;")
+	assert.Contains(t, page, "add_header Access-Control-Allow-Credentials true always;")
+	assert.Contains(t, page, "add_header Vary Origin always;")
+}
+
+func TestNextUpstream(t *testing.T) {
+	var (
+		svcs = map[string][]string{
+			"default-web":    []string{"1.2.3.4:80"},
+			"default-static": []string{"1.2.3.5:80"},
+		}
+		cfg = Config{
+			NextUpstream: NextUpstream{Conditions: "error timeout", Tries: 3},
+		}
+	)
+
+	b := testBuild(cfg, svcs, nil,
+		testIngress("default", "web", "example.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/proxy-next-upstream":         "error  http_502 non_idempotent",
+			"ingress.kubernetes.io/proxy-next-upstream-timeout": "5",
+			"ingress.kubernetes.io/backup-service":              "static",
+		}),
+		testIngress("default", "down", "down.com", "/", "missing", map[string]string{
+			"ingress.kubernetes.io/backup-service": "static",
+		}),
+		testIngress("default", "invalid", "invalid.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/proxy-next-upstream": "always",
+		}),
+	)
+
+	l := b.Servers["example.com"].Locations[0]
+	assert.Equal(t, NextUpstream{Conditions: "error http_502 non_idempotent", Tries: 3, Timeout: 5}, l.NextUpstream)
+	assert.Equal(t, backupUpstreamName("default-web", "default-static"), l.Upstream)
+	assert.Equal(t, "default-static", b.Servers["down.com"].Locations[0].Upstream, "The backup serves all requests when the primary has no pods")
+	assert.Equal(t, cfg.NextUpstream, b.Servers["invalid.com"].Locations[0].NextUpstream)
+
+	n, err := NewNginx(Config{Port: "80", HealthCheck: HealthCheck{MaxFails: 3, FailTimeout: 10}})
+	assert.Nil(t, err)
+	n.SetBackend(b)
+
+	var buf bytes.Buffer
+	assert.Nil(t, n.Render(&buf))
+	out := buf.String()
+	assert.Contains(t, out, "server 1.2.3.4:80 max_fails=3 fail_timeout=10s;")
+	assert.Contains(t, out, "server 1.2.3.5:80 backup;")
+	assert.Contains(t, out, "proxy_next_upstream error http_502 non_idempotent;")
+	assert.Contains(t, out, "proxy_next_upstream_tries 3;")
+	assert.Contains(t, out, "proxy_next_upstream_timeout 5s;")
+}
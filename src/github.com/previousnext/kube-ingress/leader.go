@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+// Annotation on the lock object holding the lease, the same one used by the Kubernetes components.
+const leaderAnnotation = "control-plane.alpha.kubernetes.io/leader"
+
+// LeaderRecord is the lease held by the leader.
+type LeaderRecord struct {
+	HolderIdentity       string           `json:"holderIdentity"`
+	LeaseDurationSeconds int              `json:"leaseDurationSeconds"`
+	AcquireTime          unversioned.Time `json:"acquireTime"`
+	RenewTime            unversioned.Time `json:"renewTime"`
+}
+
+// LeaderElector elects one of the replicas using a lease stored on an Endpoints object.
+type LeaderElector struct {
+	Client client.EndpointsInterface
+
+	// Name of the Endpoints object holding the lease.
+	Name string
+
+	// Unique name of this replica.
+	Identity string
+
+	// How long other replicas wait after the lease was last renewed before taking it over.
+	LeaseDuration time.Duration
+
+	// How long the leader keeps trying to renew the lease before giving up leadership.
+	RenewDeadline time.Duration
+
+	// How often to try acquiring or renewing the lease.
+	RetryPeriod time.Duration
+
+	mu           sync.Mutex
+	leader       bool
+	renewed      time.Time
+	observed     LeaderRecord
+	observedTime time.Time
+}
+
+// Returns true if this replica currently holds the lease.
+func (le *LeaderElector) IsLeader() bool {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+
+	return le.leader
+}
+
+// Returns the identity of the replica which was last seen holding the lease.
+func (le *LeaderElector) Leader() string {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+
+	return le.observed.HolderIdentity
+}
+
+// Tries to acquire and then keep renewing the lease until the context is cancelled, the lease
+// is released when we stop.
+func (le *LeaderElector) Run(ctx context.Context) {
+	for {
+		err := le.tryAcquireOrRenew(time.Now())
+		if err != nil {
+			fmt.Printf("Failed to acquire or renew the leader lease: %v\n", err)
+		}
+
+		le.mu.Lock()
+		started := err == nil && !le.leader
+		stopped := err != nil && le.leader && time.Since(le.renewed) > le.RenewDeadline
+		if err == nil {
+			le.leader = true
+			le.renewed = time.Now()
+		} else if stopped {
+			le.leader = false
+		}
+		le.mu.Unlock()
+
+		if started {
+			fmt.Printf("Became the leader as %s\n", le.Identity)
+		}
+		if stopped {
+			fmt.Printf("Lost the leader lease as %s\n", le.Identity)
+		}
+
+		select {
+		case <-ctx.Done():
+			if le.IsLeader() {
+				if err := le.release(time.Now()); err != nil {
+					fmt.Printf("Failed to release the leader lease: %v\n", err)
+				}
+			}
+			return
+		case <-time.After(le.RetryPeriod):
+		}
+	}
+}
+
+// Gives up the lease if we hold it, so another replica can take over without waiting for it to expire.
+func (le *LeaderElector) release(now time.Time) error {
+	le.mu.Lock()
+	le.leader = false
+	le.mu.Unlock()
+
+	e, err := le.Client.Get(le.Name)
+	if err != nil {
+		return err
+	}
+
+	var existing LeaderRecord
+	if data, ok := e.Annotations[leaderAnnotation]; ok {
+		if err := json.Unmarshal([]byte(data), &existing); err != nil {
+			return errors.New(fmt.Sprintf("Invalid leader record: %v", err))
+		}
+	}
+
+	if existing.HolderIdentity != le.Identity {
+		return nil
+	}
+
+	// A lease without a holder is free to be acquired.
+	data, err := json.Marshal(LeaderRecord{
+		LeaseDurationSeconds: existing.LeaseDurationSeconds,
+		AcquireTime:          unversioned.NewTime(now),
+		RenewTime:            unversioned.NewTime(now),
+	})
+	if err != nil {
+		return err
+	}
+	e.Annotations[leaderAnnotation] = string(data)
+
+	if _, err := le.Client.Update(e); err != nil {
+		return err
+	}
+
+	fmt.Printf("Released the leader lease as %s\n", le.Identity)
+	return nil
+}
+
+// Acquires the lease if it is free or has expired, or renews it if we already hold it. Returns
+// an error if another replica holds the lease.
+func (le *LeaderElector) tryAcquireOrRenew(now time.Time) error {
+	record := LeaderRecord{
+		HolderIdentity:       le.Identity,
+		LeaseDurationSeconds: int(le.LeaseDuration / time.Second),
+		AcquireTime:          unversioned.NewTime(now),
+		RenewTime:            unversioned.NewTime(now),
+	}
+
+	e, err := le.Client.Get(le.Name)
+	if apierrors.IsNotFound(err) {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		_, err = le.Client.Create(&api.Endpoints{
+			ObjectMeta: api.ObjectMeta{
+				Name:        le.Name,
+				Annotations: map[string]string{leaderAnnotation: string(data)},
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		le.observe(record, now)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var existing LeaderRecord
+	if data, ok := e.Annotations[leaderAnnotation]; ok {
+		if err := json.Unmarshal([]byte(data), &existing); err != nil {
+			return errors.New(fmt.Sprintf("Invalid leader record: %v", err))
+		}
+	}
+
+	// The lease is measured from when we saw it change, so clocks don't need to agree.
+	le.mu.Lock()
+	if !existing.equal(le.observed) {
+		le.observed = existing
+		le.observedTime = now
+	}
+	expires := le.observedTime.Add(le.LeaseDuration)
+	le.mu.Unlock()
+
+	if existing.HolderIdentity != "" && existing.HolderIdentity != le.Identity && expires.After(now) {
+		return errors.New(fmt.Sprintf("The lease is held by %s", existing.HolderIdentity))
+	}
+
+	// Keep the time we acquired the lease when renewing it.
+	if existing.HolderIdentity == le.Identity {
+		record.AcquireTime = existing.AcquireTime
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if e.Annotations == nil {
+		e.Annotations = make(map[string]string)
+	}
+	e.Annotations[leaderAnnotation] = string(data)
+
+	// Updates conflict if another replica changed the lease since we read it.
+	if _, err := le.Client.Update(e); err != nil {
+		return err
+	}
+
+	le.observe(record, now)
+	return nil
+}
+
+// Returns true if the records hold the same lease.
+func (r LeaderRecord) equal(o LeaderRecord) bool {
+	return r.HolderIdentity == o.HolderIdentity &&
+		r.LeaseDurationSeconds == o.LeaseDurationSeconds &&
+		r.AcquireTime.Equal(o.AcquireTime) &&
+		r.RenewTime.Equal(o.RenewTime)
+}
+
+func (le *LeaderElector) observe(record LeaderRecord, now time.Time) {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+
+	le.observed = record
+	le.observedTime = now
+}
+
+// Standard method for loading a LeaderElector object.
+func NewLeaderElector(c client.EndpointsInterface, name, identity string, lease, renew, retry time.Duration) (*LeaderElector, error) {
+	// The leader has to give up before anyone else can take over.
+	if renew >= lease {
+		return &LeaderElector{}, errors.New("The leader renew deadline must be shorter than the lease duration")
+	}
+	if retry >= renew {
+		return &LeaderElector{}, errors.New("The leader retry period must be shorter than the renew deadline")
+	}
+
+	return &LeaderElector{
+		Client:        c,
+		Name:          name,
+		Identity:      identity,
+		LeaseDuration: lease,
+		RenewDeadline: renew,
+		RetryPeriod:   retry,
+	}, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+// Stores a single Endpoints object, rejecting updates of stale versions like the API does.
+type fakeEndpoints struct {
+	e       *api.Endpoints
+	version int
+}
+
+var _ client.EndpointsInterface = &fakeEndpoints{}
+
+func (f *fakeEndpoints) Create(e *api.Endpoints) (*api.Endpoints, error) {
+	if f.e != nil {
+		return nil, apierrors.NewAlreadyExists("endpoints", e.Name)
+	}
+	return f.store(e), nil
+}
+
+func (f *fakeEndpoints) Get(name string) (*api.Endpoints, error) {
+	if f.e == nil {
+		return nil, apierrors.NewNotFound("endpoints", name)
+	}
+	e := *f.e
+	e.Annotations = make(map[string]string)
+	for k, v := range f.e.Annotations {
+		e.Annotations[k] = v
+	}
+	return &e, nil
+}
+
+func (f *fakeEndpoints) Update(e *api.Endpoints) (*api.Endpoints, error) {
+	if e.ResourceVersion != f.e.ResourceVersion {
+		return nil, apierrors.NewConflict("endpoints", e.Name, nil)
+	}
+	return f.store(e), nil
+}
+
+func (f *fakeEndpoints) store(e *api.Endpoints) *api.Endpoints {
+	f.version++
+	stored := *e
+	stored.ResourceVersion = strconv.Itoa(f.version)
+	f.e = &stored
+	return f.e
+}
+
+func (f *fakeEndpoints) List(label labels.Selector) (*api.EndpointsList, error) {
+	return nil, nil
+}
+
+func (f *fakeEndpoints) Delete(name string) error {
+	return nil
+}
+
+func (f *fakeEndpoints) Watch(label labels.Selector, field fields.Selector, resourceVersion string) (watch.Interface, error) {
+	return nil, nil
+}
+
+func (f *fakeEndpoints) holder(t *testing.T) string {
+	var r LeaderRecord
+	assert.Nil(t, json.Unmarshal([]byte(f.e.Annotations[leaderAnnotation]), &r))
+	return r.HolderIdentity
+}
+
+func TestLeaderElection(t *testing.T) {
+	var (
+		endpoints = &fakeEndpoints{}
+		now       = time.Now()
+	)
+
+	a, err := NewLeaderElector(endpoints, "kube-ingress-leader", "a", 15*time.Second, 10*time.Second, 2*time.Second)
+	assert.Nil(t, err)
+	b, err := NewLeaderElector(endpoints, "kube-ingress-leader", "b", 15*time.Second, 10*time.Second, 2*time.Second)
+	assert.Nil(t, err)
+
+	assert.Nil(t, a.tryAcquireOrRenew(now), "The lock is created by the first replica")
+	assert.Equal(t, "a", endpoints.holder(t))
+
+	assert.NotNil(t, b.tryAcquireOrRenew(now), "The lease is held by another replica")
+	assert.Equal(t, "a", b.Leader())
+
+	assert.Nil(t, a.tryAcquireOrRenew(now.Add(5*time.Second)), "The leader renews the lease")
+	assert.NotNil(t, b.tryAcquireOrRenew(now.Add(10*time.Second)), "A renewed lease starts again")
+
+	assert.Nil(t, b.tryAcquireOrRenew(now.Add(30*time.Second)), "An expired lease is taken over")
+	assert.Equal(t, "b", endpoints.holder(t))
+	assert.NotNil(t, a.tryAcquireOrRenew(now.Add(31*time.Second)), "The old leader doesn't get the lease back")
+}
+
+func TestLeaderElectionRelease(t *testing.T) {
+	var (
+		endpoints = &fakeEndpoints{}
+		now       = time.Now()
+	)
+
+	a, err := NewLeaderElector(endpoints, "kube-ingress-leader", "a", 300*time.Millisecond, 200*time.Millisecond, 10*time.Millisecond)
+	assert.Nil(t, err)
+	b, err := NewLeaderElector(endpoints, "kube-ingress-leader", "b", 300*time.Millisecond, 200*time.Millisecond, 10*time.Millisecond)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		a.Run(ctx)
+		close(done)
+	}()
+
+	for i := 0; i < 100 && !a.IsLeader(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, a.IsLeader())
+
+	cancel()
+	<-done
+
+	assert.False(t, a.IsLeader())
+	assert.Equal(t, "", endpoints.holder(t), "The lease is released when the leader stops")
+	assert.Nil(t, b.tryAcquireOrRenew(now), "A released lease is taken over straight away")
+	assert.Nil(t, a.release(now), "Only the holder releases the lease")
+	assert.Equal(t, "b", endpoints.holder(t))
+}
+
+func TestLeaderElectionTimings(t *testing.T) {
+	_, err := NewLeaderElector(&fakeEndpoints{}, "kube-ingress-leader", "a", 10*time.Second, 10*time.Second, 2*time.Second)
+	assert.NotNil(t, err, "The leader has to give up before the lease expires")
+
+	_, err = NewLeaderElector(&fakeEndpoints{}, "kube-ingress-leader", "a", 15*time.Second, 10*time.Second, 10*time.Second)
+	assert.NotNil(t, err, "The lease is retried before the deadline")
+}
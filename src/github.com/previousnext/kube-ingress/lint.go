@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/util/validation"
+)
+
+// How serious a problem is, errors fail lint.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Problem is something wrong with an ingress, which the controller works around by skipping or
+// ignoring part of it. They are logged by the controller and reported by lint.
+type Problem struct {
+	Severity string
+
+	// The kind of problem, eg. annotation, conflict or service.
+	Rule string
+
+	Namespace string
+	Name      string
+	Message   string
+
+	// Set when part of the ingress was not routed, along with the host and path when it
+	// was only part of a rule.
+	Skipped bool
+	Host    string
+	Path    string
+}
+
+// Records and logs a problem with an ingress.
+func (bu *Builder) report(i extensions.Ingress, severity, rule, format string, args ...interface{}) {
+	p := Problem{
+		Severity:  severity,
+		Rule:      rule,
+		Namespace: i.ObjectMeta.Namespace,
+		Name:      i.ObjectMeta.Name,
+		Message:   fmt.Sprintf(format, args...),
+	}
+
+	bu.Problems = append(bu.Problems, p)
+
+	if !bu.Quiet {
+		fmt.Println(p.Message)
+	}
+}
+
+// Records and logs why an ingress, or one of its hosts or paths, was not routed.
+func (bu *Builder) skip(i extensions.Ingress, host, path, rule, format string, args ...interface{}) {
+	bu.report(i, SeverityError, rule, format, args...)
+
+	p := &bu.Problems[len(bu.Problems)-1]
+	p.Skipped = true
+	p.Host = host
+	p.Path = path
+}
+
+// Warns about annotations which are most likely misspelled, as they are ignored.
+func (bu *Builder) checkAnnotations(i extensions.Ingress) {
+	unknown := unknownAnnotations(i)
+
+	var keys []string
+	for k := range unknown {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if unknown[k] == "" {
+			bu.report(i, SeverityWarning, "annotation", "Ignoring the unknown annotation %s%s on ingress %s/%s", annotationPrefix, k, i.ObjectMeta.Namespace, i.ObjectMeta.Name)
+			continue
+		}
+		bu.report(i, SeverityWarning, "annotation", "Ignoring the unknown annotation %s%s on ingress %s/%s, did you mean %s%s?", annotationPrefix, k, i.ObjectMeta.Namespace, i.ObjectMeta.Name, annotationPrefix, unknown[k])
+	}
+}
+
+// Returns false if the path of a rule cannot be served.
+func (bu *Builder) checkPath(i extensions.Ingress, host, path string) bool {
+	switch {
+	case !strings.HasPrefix(path, "/"):
+		bu.skip(i, host, path, "path", "Skipping %s%s for ingress %s/%s: the path must start with /", host, path, i.ObjectMeta.Namespace, i.ObjectMeta.Name)
+		return false
+	case strings.ContainsAny(path, " \t\r\n{};\"'#"):
+		bu.skip(i, host, path, "path", "Skipping %s%s for ingress %s/%s: the path contains characters which are not allowed in a location", host, path, i.ObjectMeta.Namespace, i.ObjectMeta.Name)
+		return false
+	case strings.ContainsAny(path, "^$*+?()[]|\\"):
+		// Paths are served as prefixes, so a regular expression only matches itself.
+		bu.report(i, SeverityWarning, "path", "Regular expressions are not supported, %s%s for ingress %s/%s is matched as a prefix", host, path, i.ObjectMeta.Namespace, i.ObjectMeta.Name)
+	}
+
+	return true
+}
+
+// Returns an error if a host cannot be used as a server name. Wildcards are allowed in the first label.
+func validHost(host string) error {
+	if host == "" || validation.IsDNS1123Subdomain(strings.TrimPrefix(host, "*.")) {
+		return nil
+	}
+	return errors.New("the host must be a lowercase DNS name")
+}
+
+// Lints the ingresses of the manifests with the rules the controller applies when building them. Services
+// and secrets are only checked when the manifests contain some, so ingresses can be linted on their own.
+func Lint(cfg Config, m Manifests) []Problem {
+	bu := manifestBuilder(cfg, m)
+
+	// Without their manifests every service is assumed to exist, so the other rules are still checked.
+	if len(m.Services) == 0 {
+		bu.Services.List = referencedServices(m.Ingresses)
+	}
+
+	bu.Build(m.Ingresses)
+
+	var l []Problem
+
+	for _, p := range bu.Problems {
+		if p.Rule == "secret" && len(m.Secrets) == 0 {
+			continue
+		}
+		l = append(l, p)
+	}
+
+	return l
+}
+
+// Returns the services the ingresses route to, mirror to or fall back to, without any pods.
+func referencedServices(ings []extensions.Ingress) map[string][]string {
+	l := make(map[string][]string)
+
+	for _, i := range ings {
+		for _, key := range []string{"mirror-target", "default-backend", "backup-service"} {
+			if svc, ok := annotation(i, key); ok {
+				l[MergeNameNameSpace(i.ObjectMeta.Namespace, svc)] = []string{}
+			}
+		}
+
+		for _, r := range i.Spec.Rules {
+			if r.HTTP == nil {
+				continue
+			}
+			for _, pa := range r.HTTP.Paths {
+				l[MergeNameNameSpace(i.ObjectMeta.Namespace, pa.Backend.ServiceName)] = []string{}
+			}
+		}
+	}
+
+	return l
+}
+
+// Loads the ingresses, services, endpoints and secrets of the cluster. The ingresses in the manifests
+// replace the ones with the same name, so they are checked against the rest of the cluster.
+func LoadClusterManifests(c *client.Client, m Manifests) (Manifests, error) {
+	ings, err := c.Extensions().Ingress(api.NamespaceAll).List(labels.Everything(), fields.Everything())
+	if err != nil {
+		return m, err
+	}
+	svcs, err := c.Services(api.NamespaceAll).List(labels.Everything())
+	if err != nil {
+		return m, err
+	}
+	eps, err := c.Endpoints(api.NamespaceAll).List(labels.Everything())
+	if err != nil {
+		return m, err
+	}
+	secrets, err := c.Secrets(api.NamespaceAll).List(labels.Everything(), fields.Everything())
+	if err != nil {
+		return m, err
+	}
+
+	cluster := Manifests{
+		Services:  append(svcs.Items, m.Services...),
+		Endpoints: append(eps.Items, m.Endpoints...),
+		Secrets:   append(secrets.Items, m.Secrets...),
+		Sources:   m.Sources,
+	}
+
+	// The ingresses from the manifests come last, so they are the ones reported when they conflict.
+	for _, i := range ings.Items {
+		if _, ok := m.Sources[i.ObjectMeta.Namespace+"/"+i.ObjectMeta.Name]; !ok {
+			cluster.Ingresses = append(cluster.Ingresses, i)
+		}
+	}
+	cluster.Ingresses = append(cluster.Ingresses, m.Ingresses...)
+
+	return cluster, nil
+}
+
+// Writes the problems found in the manifests sorted by where they were found, returning the number of
+// errors. Problems with ingresses which are not in the manifests are only written when all is set.
+func WriteProblems(w io.Writer, m Manifests, l []Problem, all bool) int {
+	type found struct {
+		Source string
+		Problem
+	}
+
+	var sorted []found
+
+	for _, p := range l {
+		source, ok := m.Sources[p.Namespace+"/"+p.Name]
+		if !ok {
+			if !all {
+				continue
+			}
+			source = p.Namespace + "/" + p.Name
+		}
+		sorted = append(sorted, found{source, p})
+	}
+
+	// Sorted by file and then line number.
+	sort.SliceStable(sorted, func(a, b int) bool {
+		fa, la := splitSource(sorted[a].Source)
+		fb, lb := splitSource(sorted[b].Source)
+		if fa != fb {
+			return fa < fb
+		}
+		return la < lb
+	})
+
+	var errs, warnings int
+
+	for _, f := range sorted {
+		fmt.Fprintf(w, "%s: %s: %s [%s]\n", f.Source, f.Severity, f.Message, f.Rule)
+
+		if f.Severity == SeverityError {
+			errs++
+		} else {
+			warnings++
+		}
+	}
+
+	fmt.Fprintf(w, "%d errors, %d warnings\n", errs, warnings)
+
+	return errs
+}
+
+// Splits file:line, the line is 0 when there is none.
+func splitSource(s string) (string, int) {
+	n := strings.LastIndex(s, ":")
+	if n < 0 {
+		return s, 0
+	}
+
+	var line int
+	if _, err := fmt.Sscanf(s[n+1:], "%d", &line); err != nil {
+		return s, 0
+	}
+
+	return s[:n], line
+}
+
+// Runs the lint command, returning the exit code.
+func lint() int {
+	if len(*cliLintFiles) == 0 && !*cliLintCluster {
+		fmt.Fprintln(os.Stderr, "Nothing to lint, pass manifests with --file or check the cluster with --cluster")
+		return 1
+	}
+
+	cfg, err := flagConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	m, err := LoadManifests(*cliLintFiles)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *cliLintCluster {
+		c, err := client.New(&client.Config{
+			Host: *cliApi,
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		m, err = LoadClusterManifests(c, m)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load the cluster: %v\n", err)
+			return 1
+		}
+	}
+
+	if WriteProblems(os.Stdout, m, Lint(cfg, m), len(*cliLintFiles) == 0) > 0 {
+		return 1
+	}
+
+	return 0
+}
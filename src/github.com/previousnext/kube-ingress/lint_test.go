@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	m, err := LoadManifests([]string{"testdata/ingresses.yaml", "testdata/lint.yaml", "testdata/services.yaml", "testdata/endpoints.yaml"})
+	assert.Nil(t, err)
+
+	var w bytes.Buffer
+	errs := WriteProblems(&w, m, Lint(Config{}, m), false)
+	assert.Equal(t, 5, errs)
+	assert.Equal(t, `testdata/lint.yaml:1: warning: Ignoring the unknown annotation ingress.kubernetes.io/ssl-redirct on ingress default/shop, did you mean ingress.kubernetes.io/ssl-redirect? [annotation]
+testdata/lint.yaml:1: error: Ignoring rate limits for ingress default/shop: Annotation ingress.kubernetes.io/limit-rps must be a non-negative number, got: fast [annotation]
+testdata/lint.yaml:1: warning: Regular expressions are not supported, example.com/shop/(.*) for ingress default/shop is matched as a prefix [path]
+testdata/lint.yaml:1: error: Skipping example.com/shop/(.*) for ingress default/shop: Cannot find the service port: default-web:8080 [port]
+testdata/lint.yaml:18: error: Skipping example.com/ for ingress default/blog: the path is already routed by default/web [conflict]
+testdata/lint.yaml:18: error: Skipping example.com/blog for ingress default/blog: Cannot find the service: default-blog [service]
+testdata/lint.yaml:18: error: Skipping Blog.example.com for ingress default/blog: the host must be a lowercase DNS name [host]
+5 errors, 2 warnings
+`, w.String())
+}
+
+func TestLintWithoutServices(t *testing.T) {
+	m, err := LoadManifests([]string{"testdata/ingresses.yaml", "testdata/lint.yaml"})
+	assert.Nil(t, err)
+
+	var rules []string
+	for _, p := range Lint(Config{}, m) {
+		rules = append(rules, p.Rule)
+	}
+	assert.NotContains(t, rules, "service", "Services are not checked without their manifests")
+	assert.Contains(t, rules, "conflict", "The services are assumed to exist")
+}
+
+func TestUnknownAnnotations(t *testing.T) {
+	i := testIngress("default", "web", "example.com", "/", "web", map[string]string{
+		"ingress.kubernetes.io/limit-rps":      "5",
+		"ingress.kubernetes.io/limit-rsp":      "5",
+		"ingress.kubernetes.io/rewrite-target": "/",
+		"kubernetes.io/ingress.class":          "nginx",
+	})
+
+	assert.Equal(t, map[string]string{
+		"limit-rsp":      "limit-rps",
+		"rewrite-target": "",
+	}, unknownAnnotations(i))
+}
@@ -1,25 +1,134 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/alecthomas/kingpin"
 	"k8s.io/kubernetes/pkg/api"
 	client "k8s.io/kubernetes/pkg/client/unversioned"
-	"k8s.io/kubernetes/pkg/fields"
-	"k8s.io/kubernetes/pkg/labels"
-	"k8s.io/kubernetes/pkg/util"
 )
 
 var (
+	// Commands.
+	cmdController   = kingpin.Command("controller", "Run the ingress controller").Default()
+	cmdRender       = kingpin.Command("render", "Render the nginx configuration for manifests without a cluster")
+	cliRenderFiles  = cmdRender.Flag("file", "YAML or JSON file of ingresses, services, endpoints and secrets").Short('f').Required().ExistingFiles()
+	cliRenderOutput = cmdRender.Flag("output", "Print the nginx configuration or the backend as JSON").Short('o').Default("nginx").Enum("nginx", "json")
+	cmdLint         = kingpin.Command("lint", "Check ingress manifests for problems the controller would skip or ignore")
+	cliLintFiles    = cmdLint.Flag("file", "YAML or JSON file of ingresses, services, endpoints and secrets").Short('f').ExistingFiles()
+	cliLintCluster  = cmdLint.Flag("cluster", "Check the ingresses against the services, secrets and other ingresses of the cluster").Default("false").Bool()
+	cmdExplain      = kingpin.Command("explain", "Explain which ingress rule serves a request, and why the others don't")
+	cliExplainHost  = cmdExplain.Flag("host", "Host of the request").Required().String()
+	cliExplainPath  = cmdExplain.Flag("path", "Path of the request").Default("/").String()
+	cliExplainFiles = cmdExplain.Flag("file", "YAML or JSON file of ingresses, services, endpoints and secrets, the cluster is used when none are passed").Short('f').ExistingFiles()
+
 	cliApi  = kingpin.Flag("api", "URL to the Kubernetes API component").Default("http://localhost").OverrideDefaultFromEnvar("KUBE_NGINX_API").String()
 	cliPort = kingpin.Flag("port", "Port to accept incoming connections on").Default("80").OverrideDefaultFromEnvar("KUBE_NGINX_PORT").String()
 	cliCfg  = kingpin.Flag("cfg", "Nginx config file").Default("/etc/nginx/nginx.conf").OverrideDefaultFromEnvar("KUBE_NGINX_CFG").String()
+
+	// Nginx process.
+	cliNginxBinary  = kingpin.Flag("nginx-binary", "Nginx binary to start once the configuration has been rendered").Default("nginx").OverrideDefaultFromEnvar("KUBE_NGINX_BINARY").String()
+	cliNginxRestart = kingpin.Flag("nginx-restart", "Restart nginx when it exits unexpectedly, otherwise the controller exits").Default("true").OverrideDefaultFromEnvar("KUBE_NGINX_RESTART").Bool()
+
+	// Shutdown.
+	cliDrainPeriod     = kingpin.Flag("drain-period", "Time nginx keeps serving after the controller reports it isn't ready when asked to stop").Default("10s").OverrideDefaultFromEnvar("KUBE_NGINX_DRAIN_PERIOD").Duration()
+	cliShutdownTimeout = kingpin.Flag("shutdown-timeout", "Time nginx is given to finish the open requests before they are closed").Default("15s").OverrideDefaultFromEnvar("KUBE_NGINX_SHUTDOWN_TIMEOUT").Duration()
+
+	// Client address handling.
+	cliRealIPHeader   = kingpin.Flag("real-ip-header", "Header containing the client address when set by a trusted proxy").Default("X-Forwarded-For").OverrideDefaultFromEnvar("KUBE_NGINX_REAL_IP_HEADER").String()
+	cliTrustedProxies = kingpin.Flag("trusted-proxies", "Comma separated list of proxy CIDRs allowed to set the client address").Default("").OverrideDefaultFromEnvar("KUBE_NGINX_TRUSTED_PROXIES").String()
+	cliProxyProtocol  = kingpin.Flag("proxy-protocol", "Accept the PROXY protocol on listeners (the client address is then taken from it)").Default("false").OverrideDefaultFromEnvar("KUBE_NGINX_PROXY_PROTOCOL").Bool()
+
+	// HTTPS.
+	cliSSLPort               = kingpin.Flag("ssl-port", "Port to accept incoming HTTPS connections on").Default("443").OverrideDefaultFromEnvar("KUBE_NGINX_SSL_PORT").String()
+	cliSSLDir                = kingpin.Flag("ssl-dir", "Directory to write certificates to").Default("/etc/nginx/ssl").OverrideDefaultFromEnvar("KUBE_NGINX_SSL_DIR").String()
+	cliSSLRedirect           = kingpin.Flag("ssl-redirect", "Redirect HTTP to HTTPS for hosts with a certificate").Default("true").OverrideDefaultFromEnvar("KUBE_NGINX_SSL_REDIRECT").Bool()
+	cliHSTS                  = kingpin.Flag("hsts", "Send the Strict-Transport-Security header on HTTPS responses").Default("true").OverrideDefaultFromEnvar("KUBE_NGINX_HSTS").Bool()
+	cliHSTSMaxAge            = kingpin.Flag("hsts-max-age", "Time in seconds clients should only use HTTPS").Default("15724800").OverrideDefaultFromEnvar("KUBE_NGINX_HSTS_MAX_AGE").Int()
+	cliHSTSIncludeSubdomains = kingpin.Flag("hsts-include-subdomains", "Apply the HSTS policy to all subdomains").Default("true").OverrideDefaultFromEnvar("KUBE_NGINX_HSTS_INCLUDE_SUBDOMAINS").Bool()
+	cliHSTSPreload           = kingpin.Flag("hsts-preload", "Allow hosts to be added to the browser HSTS preload lists").Default("false").OverrideDefaultFromEnvar("KUBE_NGINX_HSTS_PRELOAD").Bool()
+
+	// Proxy timeouts.
+	cliProxyReadTimeout = kingpin.Flag("proxy-read-timeout", "Seconds to wait for data from an upstream before closing the connection").Default("60").OverrideDefaultFromEnvar("KUBE_NGINX_PROXY_READ_TIMEOUT").Int()
+	cliProxySendTimeout = kingpin.Flag("proxy-send-timeout", "Seconds to wait for an upstream to accept data before closing the connection").Default("60").OverrideDefaultFromEnvar("KUBE_NGINX_PROXY_SEND_TIMEOUT").Int()
+
+	// TCP and UDP services.
+	cliTCPServices = kingpin.Flag("tcp-services", "File mapping external ports to namespace/service:port for TCP, eg. a mounted ConfigMap").Default("").OverrideDefaultFromEnvar("KUBE_NGINX_TCP_SERVICES").String()
+	cliUDPServices = kingpin.Flag("udp-services", "File mapping external ports to namespace/service:port for UDP, eg. a mounted ConfigMap").Default("").OverrideDefaultFromEnvar("KUBE_NGINX_UDP_SERVICES").String()
+
+	// Default backend and error pages.
+	cliDefaultBackendPort = kingpin.Flag("default-backend-port", "Port for the built in backend serving unknown hosts and error pages").Default("8181").OverrideDefaultFromEnvar("KUBE_NGINX_DEFAULT_BACKEND_PORT").String()
+	cliCustomHTTPErrors   = kingpin.Flag("custom-http-errors", "Comma separated list of status codes to replace with error pages").Default("").OverrideDefaultFromEnvar("KUBE_NGINX_CUSTOM_HTTP_ERRORS").String()
+
+	// Upstream keepalive.
+	cliKeepaliveConnections = kingpin.Flag("upstream-keepalive-connections", "Idle connections to each upstream kept open per worker, 0 to disable").Default("32").OverrideDefaultFromEnvar("KUBE_NGINX_UPSTREAM_KEEPALIVE_CONNECTIONS").Int()
+	cliKeepaliveRequests    = kingpin.Flag("upstream-keepalive-requests", "Requests served over an upstream connection before it is closed").Default("100").OverrideDefaultFromEnvar("KUBE_NGINX_UPSTREAM_KEEPALIVE_REQUESTS").Int()
+	cliKeepaliveTimeout     = kingpin.Flag("upstream-keepalive-timeout", "Seconds an idle upstream connection is kept open").Default("60").OverrideDefaultFromEnvar("KUBE_NGINX_UPSTREAM_KEEPALIVE_TIMEOUT").Int()
+
+	// Health checking and retries.
+	cliMaxFails                 = kingpin.Flag("upstream-max-fails", "Failed requests before an upstream server is marked as down, 0 to disable").Default("3").OverrideDefaultFromEnvar("KUBE_NGINX_UPSTREAM_MAX_FAILS").Int()
+	cliFailTimeout              = kingpin.Flag("upstream-fail-timeout", "Seconds failures are counted over and a server is marked down for").Default("10").OverrideDefaultFromEnvar("KUBE_NGINX_UPSTREAM_FAIL_TIMEOUT").Int()
+	cliProxyNextUpstream        = kingpin.Flag("proxy-next-upstream", "Space separated conditions for passing a request to the next upstream server").Default("error timeout").OverrideDefaultFromEnvar("KUBE_NGINX_PROXY_NEXT_UPSTREAM").String()
+	cliProxyNextUpstreamTries   = kingpin.Flag("proxy-next-upstream-tries", "Maximum attempts at passing a request to an upstream server, 0 for no limit").Default("3").OverrideDefaultFromEnvar("KUBE_NGINX_PROXY_NEXT_UPSTREAM_TRIES").Int()
+	cliProxyNextUpstreamTimeout = kingpin.Flag("proxy-next-upstream-timeout", "Seconds spent passing a request to upstream servers, 0 for no limit").Default("0").OverrideDefaultFromEnvar("KUBE_NGINX_PROXY_NEXT_UPSTREAM_TIMEOUT").Int()
+
+	// Dynamic upstreams.
+	cliDynamicUpstreams     = kingpin.Flag("dynamic-upstreams", "Push upstream server changes to nginx instead of reloading (requires OpenResty)").Default("false").OverrideDefaultFromEnvar("KUBE_NGINX_DYNAMIC_UPSTREAMS").Bool()
+	cliDynamicUpstreamsPort = kingpin.Flag("dynamic-upstreams-port", "Local port nginx accepts upstream server changes on").Default("18080").OverrideDefaultFromEnvar("KUBE_NGINX_DYNAMIC_UPSTREAMS_PORT").String()
+	cliLuaDir               = kingpin.Flag("lua-dir", "Directory to write the Lua balancer to").Default("/etc/nginx/lua").OverrideDefaultFromEnvar("KUBE_NGINX_LUA_DIR").String()
+
+	// Reload batching.
+	cliMinReloadInterval = kingpin.Flag("min-reload-interval", "Minimum time between applying changes, changes within it are batched together").Default("2s").OverrideDefaultFromEnvar("KUBE_NGINX_MIN_RELOAD_INTERVAL").Duration()
+	cliMaxReloadDelay    = kingpin.Flag("max-reload-delay", "Maximum time a change waits while further changes keep arriving").Default("30s").OverrideDefaultFromEnvar("KUBE_NGINX_MAX_RELOAD_DELAY").Duration()
+
+	// Publishing the state of the ingresses.
+	cliPublishAddress      = kingpin.Flag("publish-address", "Comma separated list of IPs or hostnames written to the status of the ingresses").Default("").OverrideDefaultFromEnvar("KUBE_NGINX_PUBLISH_ADDRESS").String()
+	cliLeaderElect         = kingpin.Flag("leader-elect", "Elect a single replica to write the status of the ingresses and record events").Default("true").OverrideDefaultFromEnvar("KUBE_NGINX_LEADER_ELECT").Bool()
+	cliElectionID          = kingpin.Flag("election-id", "Name of the endpoints holding the leader lease").Default("kube-ingress-leader").OverrideDefaultFromEnvar("KUBE_NGINX_ELECTION_ID").String()
+	cliElectionNamespace   = kingpin.Flag("election-namespace", "Namespace of the endpoints holding the leader lease").Default("default").OverrideDefaultFromEnvar("KUBE_NGINX_ELECTION_NAMESPACE").String()
+	cliLeaderLeaseDuration = kingpin.Flag("leader-lease-duration", "Time other replicas wait after the lease was last renewed before taking it over").Default("15s").OverrideDefaultFromEnvar("KUBE_NGINX_LEADER_LEASE_DURATION").Duration()
+	cliLeaderRenewDeadline = kingpin.Flag("leader-renew-deadline", "Time the leader keeps trying to renew the lease before giving up leadership").Default("10s").OverrideDefaultFromEnvar("KUBE_NGINX_LEADER_RENEW_DEADLINE").Duration()
+	cliLeaderRetryPeriod   = kingpin.Flag("leader-retry-period", "Time between attempts to acquire or renew the lease").Default("2s").OverrideDefaultFromEnvar("KUBE_NGINX_LEADER_RETRY_PERIOD").Duration()
+
+	// Route safety.
+	cliMaxRouteDrop = kingpin.Flag("max-route-drop", "Percentage of the routes a change may remove before it is refused until forced, 0 to allow any").Default("0").OverrideDefaultFromEnvar("KUBE_NGINX_MAX_ROUTE_DROP").Int()
+
+	// Last known good state.
+	cliSnapshot = kingpin.Flag("snapshot", "File the last applied configuration is saved to and served from on startup, empty to disable").Default("").OverrideDefaultFromEnvar("KUBE_NGINX_SNAPSHOT").String()
+
+	// Dry run.
+	cliDryRun = kingpin.Flag("dry-run", "Log what each change would do without writing the configuration, reloading nginx or publishing status").Default("false").OverrideDefaultFromEnvar("KUBE_NGINX_DRY_RUN").Bool()
+
+	// Admission webhook.
+	cliWebhookPort     = kingpin.Flag("webhook-port", "Port to serve the validating admission webhook for ingresses on, empty to disable").Default("").OverrideDefaultFromEnvar("KUBE_NGINX_WEBHOOK_PORT").String()
+	cliWebhookCertFile = kingpin.Flag("webhook-cert-file", "Certificate the webhook is served with").Default("").OverrideDefaultFromEnvar("KUBE_NGINX_WEBHOOK_CERT_FILE").String()
+	cliWebhookKeyFile  = kingpin.Flag("webhook-key-file", "Private key of the webhook certificate").Default("").OverrideDefaultFromEnvar("KUBE_NGINX_WEBHOOK_KEY_FILE").String()
+
+	// Status.
+	cliStatusPort = kingpin.Flag("status-port", "Port to serve the health and metrics of the controller on").Default("10254").OverrideDefaultFromEnvar("KUBE_NGINX_STATUS_PORT").String()
 )
 
 func main() {
-	kingpin.Parse()
+	switch kingpin.Parse() {
+	case cmdRender.FullCommand():
+		os.Exit(render())
+	case cmdLint.FullCommand():
+		os.Exit(lint())
+	case cmdExplain.FullCommand():
+		os.Exit(explain())
+	default:
+		runController()
+	}
+}
 
+// Runs the controller, keeping nginx in sync with the ingresses of the cluster.
+func runController() {
 	// Create a client which we can use to connect to the remote Kubernetes cluster.
 	kubeClient, err := client.New(&client.Config{
 		Host: *cliApi,
@@ -28,85 +137,229 @@ func main() {
 		panic(err)
 	}
 
+	cfg, err := flagConfig()
+	if err != nil {
+		panic(err)
+	}
+
+	// Cancelled once nginx has stopped to stop everything else.
+	ctx, cancel := context.WithCancel(context.Background())
+
 	var (
-		ingClient = kubeClient.Extensions().Ingress(api.NamespaceAll)
-		rl        = util.NewTokenBucketRateLimiter(0.1, 1)
-		svcs      = NewServices(kubeClient)
+		wg      sync.WaitGroup
+		builder = &Builder{
+			Config: cfg,
+		}
 	)
 
-	nginx, err := NewNginx(*cliPort)
+	nginx, err := NewNginx(cfg)
 	if err != nil {
 		panic(err)
 	}
 
-	// Controller loop.
-	for {
-		rl.Accept()
+	// nginx is started once the first configuration has been rendered.
+	nginx.Process = NewProcess(*cliNginxBinary, *cliCfg, *cliNginxRestart)
 
-		// Query for the current list of ingresses.
-		ings, err := ingClient.List(labels.Everything(), fields.Everything())
-		if err != nil {
-			fmt.Printf("Error retrieving ingresses: %v\n", err)
-			continue
+	// HTTPS requests for unknown hosts need a certificate to be answered with.
+	if !*cliDryRun {
+		if err := WriteDefaultCertificate(cfg.DefaultSSLCert); err != nil {
+			panic(err)
 		}
+	}
+
+	publisher := &Publisher{
+		Ingresses: kubeClient.Extensions(),
+		Events:    kubeClient,
+		Addresses: loadBalancerIngress(splitList(*cliPublishAddress)),
+		DryRun:    *cliDryRun,
+	}
 
-		// Ensure we have ingress items.
-		if len(ings.Items) <= 0 {
-			fmt.Printf("No ingresses were found\n", err)
-			continue
+	// Every replica serves the ingresses, but only the leader writes their status.
+	if *cliLeaderElect && !*cliDryRun {
+		identity, err := os.Hostname()
+		if err != nil {
+			panic(err)
 		}
 
-		var (
-			servers   = make(map[string][]Location)
-			upstreams = make(map[string][]string)
-		)
-
-		// Load up the pods for the service in this ingress.
-		for _, i := range ings.Items {
-			// Build a our listeners based on the ingress rules.
-			for _, r := range i.Spec.Rules {
-				var locations []Location
-
-				for _, pa := range r.HTTP.Paths {
-					name := MergeNameNameSpace(i.ObjectMeta.Namespace, pa.Backend.ServiceName)
-
-					// Get the list of backends from this rule.
-					list, err := svcs.Get(name)
-					if err != nil {
-						fmt.Println("Failed to get service pods: %s", err)
-						continue
-					}
-
-					// We have a set of IPs so we are now free to add the upstream and location
-					// to our nginx configuration and be a part of the next reload.
-					upstreams[name] = list
-
-					// Add this to our list of paths to implement in Nginx.
-					l := Location{
-						Path:     pa.Path,
-						Upstream: name,
-					}
-					locations = append(locations, l)
-				}
-
-				// Add our list of generated locations to the nginx backend. These have been verified
-				// as having a backend so this is a safe operation.
-				if len(locations) > 0 {
-					servers[r.Host] = locations
-				}
-			}
+		publisher.Leader, err = NewLeaderElector(kubeClient.Endpoints(*cliElectionNamespace), *cliElectionID, identity, *cliLeaderLeaseDuration, *cliLeaderRenewDeadline, *cliLeaderRetryPeriod)
+		if err != nil {
+			panic(err)
 		}
 
-		// Add the upstreams and servers to the nginx configuration.
-		nginx.SetServers(servers)
-		nginx.SetUpstreams(upstreams)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			publisher.Leader.Run(ctx)
+		}()
+	}
 
-		err = nginx.Reload()
+	// A dry run still starts from the snapshot, but never replaces it.
+	snapshotFile := *cliSnapshot
+	if *cliDryRun {
+		snapshotFile = ""
+	}
+
+	controller := &Controller{
+		Client:          kubeClient.Extensions().Ingress(api.NamespaceAll),
+		Builder:         builder,
+		Nginx:           nginx,
+		Publisher:       publisher,
+		SnapshotFile:    snapshotFile,
+		MaxRouteDrop:    *cliMaxRouteDrop,
+		TCPServicesFile: *cliTCPServices,
+		UDPServicesFile: *cliUDPServices,
+	}
+
+	// Changes are batched together so bursts only reload nginx once.
+	controller.Queue = NewSyncQueue(*cliMinReloadInterval, *cliMaxReloadDelay, controller.Sync)
+
+	// The pushed upstream servers are lost when nginx restarts.
+	nginx.Process.OnRestart = controller.Queue.Enqueue
+
+	builder.Services = NewServices(ctx, kubeClient, controller.Queue.Enqueue)
+	builder.Secrets = NewSecrets(ctx, kubeClient, *cliSSLDir, controller.Queue.Enqueue)
+	builder.Secrets.DryRun = *cliDryRun
+
+	// The webhook is loaded before anything is served, so a broken certificate stops the controller
+	// instead of ingresses quietly going unvalidated.
+	var webhook *Webhook
+	if *cliWebhookPort != "" {
+		webhook, err = NewWebhook(builder, controller.Ingresses, *cliWebhookCertFile, *cliWebhookKeyFile)
 		if err != nil {
-			fmt.Println(err)
-			continue
+			panic(err)
 		}
+	}
+
+	status := NewStatus(nginx.Process)
+	status.Force = controller.Force
+	status.Diffs = nginx.Diffs
+	status.Explain = controller.Explain
+	status.DryRun = *cliDryRun
+
+	// Serve the last known good configuration until everything has been loaded from the API.
+	if *cliSnapshot != "" {
+		if b, err := LoadSnapshot(*cliSnapshot, *cliDryRun); err != nil {
+			fmt.Printf("Not using the snapshot: %v\n", err)
+		} else {
+			nginx.SetBackend(b)
+			if err := nginx.Reload(); err != nil {
+				fmt.Printf("Failed to apply the snapshot: %v\n", err)
+			}
+		}
+	}
+
+	// Serve unknown hosts and error pages.
+	if *cliDefaultBackendPort != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			StartDefaultBackend(ctx, *cliDefaultBackendPort)
+		}()
+	}
+
+	// Expose the health and metrics of the controller.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		status.Start(ctx, *cliStatusPort)
+	}()
+
+	// Validate ingresses before they are admitted.
+	if webhook != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			webhook.Start(ctx, *cliWebhookPort)
+		}()
+	}
+
+	// Apply the changes as they are queued.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		controller.Queue.Run(ctx)
+	}()
+
+	// Controller loop.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		controller.Start(ctx)
+	}()
+
+	sig := nginx.Process.WaitForTermination()
+	fmt.Printf("Received %v, draining for %v\n", sig, *cliDrainPeriod)
 
-		fmt.Println("Successfully reloaded Nginx with updated Ingresses")
+	// Load balancers stop sending new connections once we are no longer ready, those
+	// already on their way are still served.
+	status.Drain()
+	time.Sleep(*cliDrainPeriod)
+
+	// A forced stop is still a completed shutdown, it is only logged.
+	fmt.Println("Stopping nginx")
+	if err := nginx.Process.Quit(*cliShutdownTimeout); err != nil {
+		fmt.Println(err)
 	}
+
+	cancel()
+	wg.Wait()
+
+	fmt.Println("Stopped")
+	os.Exit(0)
+}
+
+// Builds the configuration from the flags shared by the commands.
+func flagConfig() (Config, error) {
+	customHTTPErrors, err := parseStatusCodes(splitList(*cliCustomHTTPErrors))
+	if err != nil {
+		return Config{}, err
+	}
+	if len(customHTTPErrors) > 0 && *cliDefaultBackendPort == "" {
+		return Config{}, errors.New("--custom-http-errors requires the built in default backend, set --default-backend-port")
+	}
+
+	nextUpstream, err := parseNextUpstreamConditions(*cliProxyNextUpstream)
+	if err != nil {
+		return Config{}, err
+	}
+
+	return Config{
+		Port:           *cliPort,
+		RealIPHeader:   *cliRealIPHeader,
+		TrustedProxies: splitList(*cliTrustedProxies),
+		ProxyProtocol:  *cliProxyProtocol,
+		SSLPort:        *cliSSLPort,
+		DefaultSSLCert: filepath.Join(*cliSSLDir, defaultCertFile),
+		SSLRedirect:    *cliSSLRedirect,
+		HSTSEnabled:    *cliHSTS,
+		HSTS: HSTS{
+			MaxAge:            *cliHSTSMaxAge,
+			IncludeSubdomains: *cliHSTSIncludeSubdomains,
+			Preload:           *cliHSTSPreload,
+		},
+		Timeouts: Timeouts{
+			Read: *cliProxyReadTimeout,
+			Send: *cliProxySendTimeout,
+		},
+		DefaultBackendPort: *cliDefaultBackendPort,
+		CustomHTTPErrors:   customHTTPErrors,
+		Keepalive: Keepalive{
+			Connections: *cliKeepaliveConnections,
+			Requests:    *cliKeepaliveRequests,
+			Timeout:     *cliKeepaliveTimeout,
+		},
+		HealthCheck: HealthCheck{
+			MaxFails:    *cliMaxFails,
+			FailTimeout: *cliFailTimeout,
+		},
+		NextUpstream: NextUpstream{
+			Conditions: nextUpstream,
+			Tries:      *cliProxyNextUpstreamTries,
+			Timeout:    *cliProxyNextUpstreamTimeout,
+		},
+		DynamicUpstreams:     *cliDynamicUpstreams,
+		DynamicUpstreamsPort: *cliDynamicUpstreamsPort,
+		LuaDir:               *cliLuaDir,
+		DryRun:               *cliDryRun,
+	}, nil
 }
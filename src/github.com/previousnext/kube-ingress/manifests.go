@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+	_ "k8s.io/kubernetes/pkg/api/install"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	_ "k8s.io/kubernetes/pkg/apis/extensions/install"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/util/yaml"
+)
+
+// Manifests are the objects loaded from YAML or JSON files.
+type Manifests struct {
+	Ingresses []extensions.Ingress
+	Services  []api.Service
+	Endpoints []api.Endpoints
+	Secrets   []api.Secret
+
+	// Where each ingress was loaded from as file:line, keyed by namespace/name.
+	Sources map[string]string
+}
+
+// A YAML document within a file.
+type document struct {
+	File string
+	Line int
+	Data []byte
+}
+
+// Loads the ingresses, services, endpoints and secrets from files. Other kinds of objects are skipped.
+func LoadManifests(paths []string) (Manifests, error) {
+	var m Manifests
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return m, err
+		}
+
+		for _, doc := range documents(path, data) {
+			obj, err := doc.decode()
+			if err != nil {
+				return m, err
+			}
+			if obj == nil {
+				continue
+			}
+			if err := m.add(obj, fmt.Sprintf("%s:%d", doc.File, doc.Line)); err != nil {
+				return m, errors.New(fmt.Sprintf("%s:%d: %v", doc.File, doc.Line, err))
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// Adds an object, or the items of a list, to the manifests.
+func (m *Manifests) add(obj runtime.Object, source string) error {
+	if runtime.IsListType(obj) {
+		items, err := runtime.ExtractList(obj)
+		if err != nil {
+			return err
+		}
+
+		// Items of generic lists are left encoded.
+		if errs := runtime.DecodeList(items, api.Scheme); len(errs) > 0 {
+			return errs[0]
+		}
+
+		for _, item := range items {
+			if err := m.add(item, source); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch o := obj.(type) {
+	case *extensions.Ingress:
+		defaultNamespace(&o.ObjectMeta)
+		m.Ingresses = append(m.Ingresses, *o)
+
+		if m.Sources == nil {
+			m.Sources = make(map[string]string)
+		}
+		m.Sources[o.ObjectMeta.Namespace+"/"+o.ObjectMeta.Name] = source
+	case *api.Service:
+		defaultNamespace(&o.ObjectMeta)
+		m.Services = append(m.Services, *o)
+	case *api.Endpoints:
+		defaultNamespace(&o.ObjectMeta)
+		m.Endpoints = append(m.Endpoints, *o)
+	case *api.Secret:
+		defaultNamespace(&o.ObjectMeta)
+		m.Secrets = append(m.Secrets, *o)
+	}
+
+	return nil
+}
+
+// Decodes the object in a document, returns nil if the document is empty.
+func (d document) decode() (runtime.Object, error) {
+	if len(bytes.TrimSpace(d.Data)) <= 0 {
+		return nil, nil
+	}
+
+	data, err := yaml.ToJSON(d.Data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s:%d: %v", d.File, d.Line, err))
+	}
+
+	// Documents holding only comments.
+	if string(data) == "null" {
+		return nil, nil
+	}
+
+	obj, err := api.Scheme.Decode(data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s:%d: %v", d.File, d.Line, err))
+	}
+
+	return obj, nil
+}
+
+// Splits a file into its YAML documents, keeping the line each one starts on.
+func documents(file string, data []byte) []document {
+	var (
+		docs  []document
+		lines = strings.SplitAfter(string(data), "\n")
+		doc   = document{File: file, Line: 1}
+		buf   bytes.Buffer
+	)
+
+	for i, line := range lines {
+		if strings.HasPrefix(line, "---") {
+			doc.Data = append([]byte(nil), buf.Bytes()...)
+			docs = append(docs, doc)
+
+			buf.Reset()
+			doc = document{File: file, Line: i + 2}
+			continue
+		}
+		buf.WriteString(line)
+	}
+
+	doc.Data = buf.Bytes()
+	return append(docs, doc)
+}
+
+// Objects without a namespace are created in the default one.
+func defaultNamespace(meta *api.ObjectMeta) {
+	if meta.Namespace == "" {
+		meta.Namespace = api.NamespaceDefault
+	}
+}
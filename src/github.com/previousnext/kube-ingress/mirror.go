@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// Mirror sends a copy of the requests for a location to a shadow service. The
+// responses from the mirror are ignored.
+type Mirror struct {
+	// Name used for the internal location and variables.
+	Name string
+
+	// Service the requests are mirrored to.
+	Service  string
+	Upstream string
+
+	// Percentage of requests which are mirrored.
+	Sample int
+
+	// Send the request body to the mirror.
+	RequestBody bool
+}
+
+// Loads the mirror settings of an ingress, returns nil if requests are not mirrored.
+func parseMirror(i extensions.Ingress) (*Mirror, error) {
+	var (
+		m   = &Mirror{}
+		err error
+	)
+
+	m.Service, _ = annotation(i, "mirror-target")
+	if m.Service == "" {
+		return nil, nil
+	}
+
+	if m.Sample, err = annotationInt(i, "mirror-sample", 100); err != nil {
+		return nil, err
+	}
+	if m.Sample > 100 {
+		return nil, errors.New(fmt.Sprintf("Mirror sample must be between 0 and 100, got: %d", m.Sample))
+	}
+	if m.Sample == 0 {
+		return nil, nil
+	}
+
+	if m.RequestBody, err = annotationBool(i, "mirror-request-body", true); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Path of the internal location which passes the mirrored requests on.
+func (m Mirror) Path() string {
+	return "/_mirror_" + m.Name
+}
+
+// Variable which is set when the request has been sampled.
+func (m Mirror) SampleVariable() string {
+	return "$mirror_" + m.Name + "_sample"
+}
+
+// Resolves the mirror target of a location, returns nil if the service is not available.
+func (bu *Builder) mirror(b *Backend, i extensions.Ingress, m *Mirror, host, path string) *Mirror {
+	if m == nil {
+		return nil
+	}
+
+	name := MergeNameNameSpace(i.ObjectMeta.Namespace, m.Service)
+
+	// The primary location is still served if the mirror cannot be found.
+	list, err := bu.Services.Get(name)
+	if err != nil {
+		bu.report(i, SeverityError, "service", "Not mirroring %s%s for ingress %s/%s: %s", host, path, i.ObjectMeta.Namespace, i.ObjectMeta.Name, err)
+		return nil
+	}
+	b.Upstreams[name] = list
+
+	mirror := *m
+	mirror.Name = zoneName(i.ObjectMeta.Namespace, i.ObjectMeta.Name+"/"+host+path)
+	mirror.Upstream = name
+	b.Mirrors[mirror.Name] = mirror
+
+	return &mirror
+}
@@ -1,73 +1,639 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"reflect"
 	"text/template"
+	"time"
 )
 
 const (
 	tpl = `events {
   worker_connections  4096;
 }
+{{ if or .New.TCP .New.UDP }}
+stream {
+{{ range $port, $stream := .New.TCP }}
+    # {{ $stream.Service }}
+    upstream tcp_{{ $port }} {
+{{ range $address := $stream.Addresses }}
+        server {{ $address }};
+{{ end }}
+    }
+
+    server {
+        listen {{ $port }}{{ if $.Config.ProxyProtocol }} proxy_protocol{{ end }};
+        proxy_pass tcp_{{ $port }};
+    }
+{{ end }}
+{{ range $port, $stream := .New.UDP }}
+    # {{ $stream.Service }}
+    upstream udp_{{ $port }} {
+{{ range $address := $stream.Addresses }}
+        server {{ $address }};
+{{ end }}
+    }
+
+    server {
+        listen {{ $port }} udp;
+        proxy_pass udp_{{ $port }};
+    }
+{{ end }}
+}
+{{ end }}
     
 http {
-    real_ip_header    X-Forwarded-For;
-    set_real_ip_from  0.0.0.0/0;
+{{ if .Config.TrustedProxies }}
+    real_ip_header    {{ if .Config.ProxyProtocol }}proxy_protocol{{ else }}{{ .Config.RealIPHeader }}{{ end }};
+{{ range $proxy := .Config.TrustedProxies }}
+    set_real_ip_from  {{ $proxy }};
+{{ end }}
     real_ip_recursive on;
+{{ end }}
+
+{{ if .Config.DynamicUpstreams }}
+    # The servers of the upstreams are pushed by the controller to avoid reloads.
+    lua_shared_dict kube_ingress_upstreams 10m;
+    lua_package_path "{{ .Config.LuaDir }}/?.lua;;";
+
+    init_by_lua_block {
+        balancer = require("kube_ingress_balancer")
+    }
+
+    init_worker_by_lua_block {
+        balancer.init_worker()
+    }
+
+    server {
+        listen 127.0.0.1:{{ .Config.DynamicUpstreamsPort }};
+
+        location = /configuration/upstreams {
+            client_max_body_size    10m;
+            client_body_buffer_size 10m;
+            content_by_lua_block {
+                balancer.configure()
+            }
+        }
+    }
+{{ end }}
+
+    # Clearing the Connection header allows connections to the upstreams to be reused.
+    map $http_upgrade $connection_upgrade {
+        default upgrade;
+        ''      {{ if .Config.Keepalive.Connections }}""{{ else }}close{{ end }};
+    }
+
+{{ range $zone, $limit := .New.RateLimits }}
+    geo $limit_{{ $zone }}_whitelisted {
+        default 0;
+{{ range $cidr := $limit.Whitelist }}
+        {{ $cidr }} 1;
+{{ end }}
+    }
+
+    map $limit_{{ $zone }}_whitelisted $limit_{{ $zone }}_key {
+        0 $binary_remote_addr;
+        1 "";
+    }
+{{ if $limit.RPS }}
+    limit_req_zone $limit_{{ $zone }}_key zone={{ $zone }}_rps:10m rate={{ $limit.RPS }}r/s;
+{{ end }}
+{{ if $limit.RPM }}
+    limit_req_zone $limit_{{ $zone }}_key zone={{ $zone }}_rpm:10m rate={{ $limit.RPM }}r/m;
+{{ end }}
+{{ if $limit.Connections }}
+    limit_conn_zone $limit_{{ $zone }}_key zone={{ $zone }}_conn:10m;
+{{ end }}
+{{ end }}
+
+{{ range $name, $canary := .New.Canaries }}
+{{ if eq $canary.Weight 0 }}
+    map "" {{ $canary.WeightVariable }} {
+        default {{ $canary.Primary }};
+    }
+{{ else }}
+    split_clients "${remote_addr}${request_id}" {{ $canary.WeightVariable }} {
+        {{ $canary.Weight }}% {{ $canary.Upstream }};
+{{ if lt $canary.Weight 100 }}
+        * {{ $canary.Primary }};
+{{ end }}
+    }
+{{ end }}
+{{ if $canary.Cookie }}
+
+    map {{ $canary.RequestCookie }} {{ $canary.CookieVariable }} {
+        always  {{ $canary.Upstream }};
+        never   {{ $canary.Primary }};
+        default {{ $canary.WeightVariable }};
+    }
+{{ end }}
+{{ if $canary.Header }}
+
+    map {{ $canary.RequestHeader }} {{ $canary.HeaderVariable }} {
+{{ if $canary.HeaderValue }}
+        "{{ $canary.HeaderValue }}" {{ $canary.Upstream }};
+{{ else }}
+        always  {{ $canary.Upstream }};
+        never   {{ $canary.Primary }};
+{{ end }}
+        default {{ $canary.HeaderDefault }};
+    }
+{{ end }}
+{{ end }}
+
+{{ range $name, $mirror := .New.Mirrors }}
+{{ if lt $mirror.Sample 100 }}
+    split_clients "${request_id}" {{ $mirror.SampleVariable }} {
+        {{ $mirror.Sample }}% 1;
+        * "";
+    }
+{{ end }}
+{{ end }}
+
+{{ range $name, $cors := .New.CORS }}
+{{ if $cors.Origins }}
+    map $http_origin {{ $cors.OriginVariable }} {
+        default "";
+{{ range $origin := $cors.Origins }}
+        "{{ $origin }}" $http_origin;
+{{ end }}
+    }
+{{ end }}
+{{ end }}
+
+{{ if .Config.DefaultBackendPort }}
+    upstream kube_ingress_default_backend {
+        server 127.0.0.1:{{ .Config.DefaultBackendPort }};
+    }
+
+    # Requests for unknown hosts.
+    server {
+        listen      {{ $.Config.Port }} default_server{{ if $.Config.ProxyProtocol }} proxy_protocol{{ end }};
+{{ template "defaultssl" $.Config }}
+        server_name _;
+
+        location / {
+            proxy_set_header Host $host;
+            proxy_set_header X-Request-ID $request_id;
+            proxy_pass http://kube_ingress_default_backend;
+        }
+    }
+{{ else }}
+    # Requests for unknown hosts.
+    server {
+        listen      {{ $.Config.Port }} default_server{{ if $.Config.ProxyProtocol }} proxy_protocol{{ end }};
+{{ template "defaultssl" $.Config }}
+        server_name _;
+
+        return 404;
+    }
+{{ end }}
 
 {{ range $ud, $addresses := .New.Upstreams }}
     upstream {{ $ud }} {
+{{ if $.Config.DynamicUpstreams }}
+{{ template "balancer" $ud }}
+{{ else }}
         ip_hash;
 {{ range $ad, $address := $addresses }}
-        server {{ $address }};
+        server {{ $address }}{{ template "healthcheck" $.Config.HealthCheck }};
 {{ end }}
+{{ end }}
+{{ template "keepalive" $.Config.Keepalive }}
+    }
+{{ end }}
+
+{{ range $name, $backup := .New.Backups }}
+    # The backup servers only receive requests when all of the other servers are down.
+    upstream {{ $name }} {
+{{ if $.Config.DynamicUpstreams }}
+{{ template "balancer" $name }}
+{{ else }}
+{{ range $address := index $.New.Upstreams $backup.Primary }}
+        server {{ $address }}{{ template "healthcheck" $.Config.HealthCheck }};
+{{ end }}
+{{ range $address := index $.New.Upstreams $backup.Backup }}
+        server {{ $address }} backup;
+{{ end }}
+{{ end }}
+{{ template "keepalive" $.Config.Keepalive }}
     }
 {{ end }}
 
-{{ range $sd, $servers := .New.Servers }}
+{{ range $host, $server := .New.Servers }}
+{{ if $server.RedirectTo }}
     server {
-        listen      {{ $.Port }};
-        server_name {{ $sd }};
+        listen      {{ $.Config.Port }}{{ if $.Config.ProxyProtocol }} proxy_protocol{{ end }};
+{{ with $cert := $server.SSLCert }}
+        listen      {{ $.Config.SSLPort }} ssl{{ if $.Config.ProxyProtocol }} proxy_protocol{{ end }};
+        ssl_certificate     {{ $cert.PemFile }};
+        ssl_certificate_key {{ $cert.PemFile }};
+{{ end }}
+        server_name {{ $host }};
+        return 308 $scheme://{{ $server.RedirectTo }}$request_uri;
+    }
+{{ else }}
+{{ if and $server.SSLCert $server.SSLRedirect }}
+    server {
+        listen      {{ $.Config.Port }}{{ if $.Config.ProxyProtocol }} proxy_protocol{{ end }};
+        server_name {{ $host }};
+        return 308 https://$host{{ if ne $.Config.SSLPort "443" }}:{{ $.Config.SSLPort }}{{ end }}$request_uri;
+    }
+{{ end }}
+
+    server {
+{{ if not (and $server.SSLCert $server.SSLRedirect) }}
+        listen      {{ $.Config.Port }}{{ if $.Config.ProxyProtocol }} proxy_protocol{{ end }};
+{{ end }}
+{{ with $cert := $server.SSLCert }}
+        listen      {{ $.Config.SSLPort }} ssl{{ if $.Config.ProxyProtocol }} proxy_protocol{{ end }};
+        ssl_certificate     {{ $cert.PemFile }};
+        ssl_certificate_key {{ $cert.PemFile }};
+{{ end }}
+        server_name {{ $host }};
+{{ if $server.HTTP2 }}
+        http2 on;
+{{ end }}
+{{ if $server.ForceSSLRedirect }}
+        if ($http_x_forwarded_proto = "http") {
+            return 308 https://$host$request_uri;
+        }
+{{ end }}
+{{ if and $server.HSTS (or $server.SSLCert $server.ForceSSLRedirect) }}
+        add_header Strict-Transport-Security "{{ $server.HSTS.Header }}" always;
+{{ end }}
         proxy_set_header Host $host;
         proxy_set_header X-Real-IP $remote_addr;
         proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
         proxy_set_header X-Forwarded-Proto $scheme;
 
-{{ range $ld, $location := $servers }}
+        # Upgrade connections, eg. WebSockets, need HTTP/1.1 to the upstream.
+        proxy_http_version 1.1;
+        proxy_set_header Upgrade $http_upgrade;
+        proxy_set_header Connection $connection_upgrade;
+
+{{ range $name, $page := $server.ErrorPages }}
+        location {{ $name }} {
+            proxy_set_header Host $host;
+            proxy_set_header X-Code {{ $page.Code }};
+            proxy_set_header X-Host $host;
+            proxy_set_header X-Original-URI $request_uri;
+            proxy_set_header X-Request-ID $request_id;
+            proxy_set_header Connection "";
+            proxy_pass http://{{ $page.Upstream }};
+{{ with $cors := $page.CORS }}
+            add_header Access-Control-Allow-Origin {{ $cors.AllowOrigin }} always;
+{{ if $cors.Credentials }}
+            add_header Access-Control-Allow-Credentials true always;
+{{ end }}
+            add_header Vary Origin always;
+{{ end }}
+        }
+
+{{ end }}
+{{ range $ld, $location := $server.Locations }}
+{{ with $mirror := $location.Mirror }}
+        location = {{ $mirror.Path }} {
+            internal;
+{{ if lt $mirror.Sample 100 }}
+            if ({{ $mirror.SampleVariable }} = "") {
+                return 204;
+            }
+{{ end }}
+            proxy_set_header Host $host;
+            proxy_set_header X-Real-IP $remote_addr;
+            proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+            proxy_set_header X-Original-URI $request_uri;
+            proxy_set_header Connection "";
+{{ if not $mirror.RequestBody }}
+            proxy_pass_request_body off;
+            proxy_set_header Content-Length "";
+{{ end }}
+            proxy_pass http://{{ $mirror.Upstream }}$request_uri;
+        }
+
+{{ end }}
         location {{ $location.Path }} {
-            proxy_pass http://{{ $location.Upstream }};
+{{ with $cors := $location.CORS }}
+            # Preflight requests are answered without reaching the upstream.
+            if ($request_method = OPTIONS) {
+                add_header Access-Control-Allow-Origin {{ $cors.AllowOrigin }} always;
+                add_header Access-Control-Allow-Methods "{{ $cors.Methods }}" always;
+                add_header Access-Control-Allow-Headers "{{ $cors.Headers }}" always;
+{{ if $cors.Credentials }}
+                add_header Access-Control-Allow-Credentials true always;
+{{ end }}
+                add_header Access-Control-Max-Age {{ $cors.MaxAge }} always;
+                add_header Vary Origin always;
+                return 204;
+            }
+
+            add_header Access-Control-Allow-Origin {{ $cors.AllowOrigin }} always;
+{{ if $cors.Credentials }}
+            add_header Access-Control-Allow-Credentials true always;
+{{ end }}
+            add_header Vary Origin always;
+{{ if and $server.HSTS (or $server.SSLCert $server.ForceSSLRedirect) }}
+            add_header Strict-Transport-Security "{{ $server.HSTS.Header }}" always;
+{{ end }}
+{{ end }}
+{{ if $location.Redirect }}
+            return {{ $location.Redirect.Code }} {{ $location.Redirect.URL }};
+{{ else }}
+{{ with $mirror := $location.Mirror }}
+            mirror {{ $mirror.Path }};
+            mirror_request_body {{ if $mirror.RequestBody }}on{{ else }}off{{ end }};
+{{ end }}
+{{ with $errors := $location.CustomErrors }}
+            {{ if $location.GRPC }}grpc_intercept_errors{{ else }}proxy_intercept_errors{{ end }} on;
+{{ range $code := $errors.Codes }}
+            error_page {{ $code }} = {{ $errors.Location $code }};
+{{ end }}
+{{ end }}
+{{ with $limit := $location.RateLimit }}
+{{ if $limit.RPS }}
+            limit_req zone={{ $limit.Zone }}_rps{{ if $limit.Burst }} burst={{ $limit.Burst }} nodelay{{ end }};
+{{ end }}
+{{ if $limit.RPM }}
+            limit_req zone={{ $limit.Zone }}_rpm{{ if $limit.Burst }} burst={{ $limit.Burst }} nodelay{{ end }};
+{{ end }}
+{{ if $limit.Connections }}
+            limit_conn {{ $limit.Zone }}_conn {{ $limit.Connections }};
+{{ end }}
+{{ end }}
+{{ if $location.GRPC }}
+            grpc_set_header X-Real-IP $remote_addr;
+            grpc_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+            grpc_set_header X-Forwarded-Proto $scheme;
+{{ if $location.Timeouts.Read }}
+            grpc_read_timeout {{ $location.Timeouts.Read }}s;
+{{ end }}
+{{ if $location.Timeouts.Send }}
+            grpc_send_timeout {{ $location.Timeouts.Send }}s;
+{{ end }}
+{{ with $tls := $location.UpstreamTLS }}
+{{ if $tls.Verify }}
+            grpc_ssl_verify on;
+            grpc_ssl_trusted_certificate {{ $tls.CAFile }};
+{{ end }}
+{{ if $tls.Name }}
+            grpc_ssl_name {{ $tls.Name }};
+{{ end }}
+            grpc_ssl_server_name on;
+{{ end }}
+{{ with $next := $location.NextUpstream }}
+{{ if $next.Conditions }}
+            grpc_next_upstream {{ $next.Conditions }};
+            grpc_next_upstream_tries {{ $next.Tries }};
+            grpc_next_upstream_timeout {{ $next.Timeout }}s;
+{{ end }}
+{{ end }}
+            grpc_pass {{ $location.Scheme }}://{{ $location.Target }};
+{{ else }}
+{{ if $location.Timeouts.Read }}
+            proxy_read_timeout {{ $location.Timeouts.Read }}s;
+{{ end }}
+{{ if $location.Timeouts.Send }}
+            proxy_send_timeout {{ $location.Timeouts.Send }}s;
+{{ end }}
+{{ with $tls := $location.UpstreamTLS }}
+{{ if $tls.Verify }}
+            proxy_ssl_verify on;
+            proxy_ssl_trusted_certificate {{ $tls.CAFile }};
+{{ end }}
+{{ if $tls.Name }}
+            proxy_ssl_name {{ $tls.Name }};
+{{ end }}
+            proxy_ssl_server_name on;
+{{ end }}
+{{ with $next := $location.NextUpstream }}
+{{ if $next.Conditions }}
+            proxy_next_upstream {{ $next.Conditions }};
+            proxy_next_upstream_tries {{ $next.Tries }};
+            proxy_next_upstream_timeout {{ $next.Timeout }}s;
+{{ end }}
+{{ end }}
+            proxy_pass {{ $location.Scheme }}://{{ $location.Target }};
+{{ end }}
+{{ end }}
         }
 {{ end }}
     }
 {{ end }}
-}`
+{{ end }}
+}
+{{ define "balancer" }}
+        # Placeholder, the server is picked by the balancer.
+        server 0.0.0.1;
+        balancer_by_lua_block {
+            balancer.balance("{{ . }}")
+        }
+{{ end }}
+{{ define "healthcheck" }}{{ if .FailTimeout }} max_fails={{ .MaxFails }} fail_timeout={{ .FailTimeout }}s{{ end }}{{ end }}
+
+{{ define "defaultssl" }}
+{{ if .DefaultSSLCert }}
+        listen      {{ .SSLPort }} ssl default_server{{ if .ProxyProtocol }} proxy_protocol{{ end }};
+        ssl_certificate     {{ .DefaultSSLCert }};
+        ssl_certificate_key {{ .DefaultSSLCert }};
+{{ end }}
+{{ end }}
+
+{{ define "keepalive" }}
+{{ if .Connections }}
+        keepalive {{ .Connections }};
+{{ if .Requests }}
+        keepalive_requests {{ .Requests }};
+{{ end }}
+{{ if .Timeout }}
+        keepalive_timeout {{ .Timeout }}s;
+{{ end }}
+{{ end }}
+{{ end }}`
 )
 
+// Config holds the global settings which apply to every server.
+type Config struct {
+	Port string
+
+	// Header used to determine the client address, only trusted when the request
+	// comes from one of the TrustedProxies.
+	RealIPHeader   string
+	TrustedProxies []string
+
+	// Accept the PROXY protocol on the listeners, eg. when behind an L4 load balancer.
+	ProxyProtocol bool
+
+	// Port to accept HTTPS connections on for hosts which have a certificate.
+	SSLPort string
+
+	// Certificate the default server answers HTTPS requests for unknown hosts with, no default
+	// HTTPS server is rendered without one.
+	DefaultSSLCert string
+
+	// Defaults for the HTTPS settings, these can be overridden per ingress.
+	SSLRedirect bool
+	HSTSEnabled bool
+	HSTS        HSTS
+
+	// Default timeouts for proxied connections.
+	Timeouts Timeouts
+
+	// Port of the built in backend serving unknown hosts and error pages.
+	DefaultBackendPort string
+
+	// Status codes which are replaced with error pages by default.
+	CustomHTTPErrors []int
+
+	// Connections to the upstreams which are kept open for reuse.
+	Keepalive Keepalive
+
+	// Passive health checking of upstream servers.
+	HealthCheck HealthCheck
+
+	// Default retry policy for failed requests.
+	NextUpstream NextUpstream
+
+	// Push changes to the upstream servers to nginx instead of reloading, requires OpenResty.
+	DynamicUpstreams     bool
+	DynamicUpstreamsPort string
+	LuaDir               string
+
+	// Log what would change without writing the configuration or reloading nginx.
+	DryRun bool
+}
+
+// Keepalive configures the pool of idle connections to each upstream.
+type Keepalive struct {
+	// Idle connections kept open per worker, 0 disables keepalive.
+	Connections int
+
+	// Requests served over a connection before it is closed.
+	Requests int
+
+	// Seconds an idle connection is kept open.
+	Timeout int
+}
+
+// Server is a virtual host made up of the locations from the ingress rules.
+type Server struct {
+	Locations []Location
+
+	// Accept HTTP/2 connections, required for gRPC.
+	HTTP2 bool
+
+	// The certificate used to terminate TLS, nil when the host only serves HTTP.
+	SSLCert *SSLCert
+
+	// Redirect plain HTTP to HTTPS when the host has a certificate.
+	SSLRedirect bool
+
+	// Redirect to HTTPS when TLS was terminated before reaching nginx.
+	ForceSSLRedirect bool
+
+	HSTS *HSTS
+
+	// Internal locations serving the custom error pages, keyed by location name.
+	ErrorPages map[string]ErrorPage
+
+	// Redirect the www. or bare version of the host to this server.
+	FromToWWW bool
+
+	// Host which all requests are redirected to, used for the www. redirects.
+	RedirectTo string
+}
+
+// Returns the index of the location for a path, -1 if it does not exist.
+func (s Server) location(path string) int {
+	for n, l := range s.Locations {
+		if l.Path == path {
+			return n
+		}
+	}
+	return -1
+}
+
 type Location struct {
 	Path     string
 	Upstream string
+
+	// The namespace/name of the ingress the location was built from.
+	Ingress string
+
+	RateLimit *RateLimit
+	Timeouts  Timeouts
+
+	// Protocol used to connect to the upstream, eg. HTTP or GRPCS.
+	Protocol    string
+	UpstreamTLS *UpstreamTLS
+
+	// Traffic split with a canary ingress, nil when all requests go to the Upstream.
+	Canary *Canary
+
+	// Shadow service which receives a copy of the requests, nil when not mirrored.
+	Mirror *Mirror
+
+	// Status codes which are replaced with custom error pages, nil to pass them through.
+	CustomErrors *CustomErrors
+
+	// Answer requests with a redirect instead of proxying them.
+	Redirect *Redirect
+
+	// Cross origin requests which are allowed, nil when CORS is not handled.
+	CORS *CORS
+
+	// When failed requests are passed on to the next upstream server.
+	NextUpstream NextUpstream
 }
 
 type Backend struct {
-	Servers   map[string][]Location
-	Upstreams map[string][]string
+	Servers    map[string]Server
+	Upstreams  map[string][]string
+	RateLimits map[string]RateLimit
+	Canaries   map[string]Canary
+	Mirrors    map[string]Mirror
+	CORS       map[string]CORS
+
+	// Upstreams which fall back to a backup service, keyed by upstream name.
+	Backups map[string]BackupUpstream
+
+	// Streams keyed by the port they are exposed on.
+	TCP map[string]Stream
+	UDP map[string]Stream
 }
 
+// Returned by Reload when the configuration is the same as the one nginx is running.
+var ErrNotChanged = errors.New("Configuration has not changed. Not reloading the nginx daemon.")
+
 type Nginx struct {
 	Template *template.Template
-	Port     string
+	Config   Config
 
 	// New configuration to be compared with against the private values.
 	New Backend
 
 	// The previously reloaded values.
 	Prev Backend
+
+	// The nginx master process the configuration is applied to.
+	Process *Process
+
+	// The changes made by the last render.
+	Diffs *DiffLog
+
+	// The upstream servers last pushed to nginx when using dynamic upstreams.
+	posted map[string]DynamicUpstream
+
+	// The start of nginx the upstream servers were pushed to.
+	postedStart int
 }
 
-func (n *Nginx) SetServers(l map[string][]Location) {
+func (n *Nginx) SetServers(l map[string]Server) {
 	n.New.Servers = l
 }
 
@@ -75,34 +641,131 @@ func (n *Nginx) SetUpstreams(l map[string][]string) {
 	n.New.Upstreams = l
 }
 
+func (n *Nginx) SetBackend(b Backend) {
+	n.New = b
+}
+
 func (n *Nginx) Reload() error {
+	// A restarted nginx has lost the upstream servers pushed to it.
+	if n.Process != nil && n.Process.Starts() != n.postedStart {
+		n.posted = nil
+	}
+
 	// Has the configuration changed? If it has we can reload.
-	if reflect.DeepEqual(n.New.Servers, n.Prev.Servers) && reflect.DeepEqual(n.New.Upstreams, n.Prev.Upstreams) {
-		return errors.New("Configuration has not changed. Not reloading the nginx daemon.")
+	if reflect.DeepEqual(n.New, n.Prev) && (n.Config.DryRun || !n.pendingUpstreams()) {
+		return ErrNotChanged
+	}
+
+	if err := n.diff(); err != nil {
+		return err
+	}
+
+	if n.Config.DryRun {
+		n.Prev = n.New
+		fmt.Println("Dry run, not writing the configuration or reloading the nginx daemon")
+
+		return nil
+	}
+
+	// When only the servers of the upstreams changed they can be pushed without a reload.
+	if n.Config.DynamicUpstreams && reflect.DeepEqual(withoutEndpoints(n.New), withoutEndpoints(n.Prev)) {
+		if err := n.postUpstreams(); err != nil {
+			return err
+		}
+
+		n.Prev = n.New
+		fmt.Println("Updated the upstream servers without reloading the nginx daemon")
+
+		return nil
+	}
+
+	if n.Config.DynamicUpstreams {
+		if err := n.writeBalancer(); err != nil {
+			return errors.New(fmt.Sprintf("Failed to write the balancer: %v\n", err))
+		}
 	}
 
 	// Build a new configuration.
-	if w, err := os.Create(*cliCfg); err != nil {
-		return errors.New(fmt.Sprintf("Failed to open %v: %v\n", tpl, err))
-	} else if err := n.Template.Execute(w, n); err != nil {
+	w, err := os.Create(*cliCfg)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to open %v: %v\n", *cliCfg, err))
+	}
+	defer w.Close()
+
+	if err := n.Render(w); err != nil {
 		return errors.New(fmt.Sprintf("Failed to write template %v\n", err))
 	}
 
-	// Reload the active daemon.
-	err := shellOut("nginx -s reload")
+	// Start nginx or reload the running master process.
+	err = n.Process.Reload()
 	if err != nil {
 		return err
 	}
 
 	// Set the previous values so Nginx doesn't continue to restart.
-	n.Prev.Servers = n.New.Servers
-	n.Prev.Upstreams = n.New.Upstreams
+	n.Prev = n.New
+
+	// The new upstreams need their servers before they can serve requests.
+	if n.Config.DynamicUpstreams {
+		return n.waitPostUpstreams()
+	}
 
 	return nil
 }
 
+// Render writes the nginx configuration for the New backend.
+func (n *Nginx) Render(w io.Writer) error {
+	return n.Template.Execute(w, n)
+}
+
+// Logs and records what has changed between the Prev and New backends.
+func (n *Nginx) diff() error {
+	var prev, next bytes.Buffer
+
+	if err := n.renderBackend(&prev, n.Prev); err != nil {
+		return errors.New(fmt.Sprintf("Failed to write template %v\n", err))
+	}
+
+	if err := n.Render(&next); err != nil {
+		return errors.New(fmt.Sprintf("Failed to write template %v\n", err))
+	}
+
+	d := Diff{
+		Time:    time.Now(),
+		Changes: DiffBackends(n.Prev, n.New),
+		Text:    diffText(prev.String(), next.String()),
+	}
+
+	for _, c := range d.Changes {
+		fmt.Printf("Change: %s\n", c)
+	}
+
+	if n.Diffs != nil {
+		n.Diffs.Set(d)
+	}
+
+	return nil
+}
+
+// Renders the configuration for another backend with the same settings.
+func (n *Nginx) renderBackend(w io.Writer, b Backend) error {
+	c := *n
+	c.New = b
+	return c.Render(w)
+}
+
 // Standard method for loading a Nginx configuration.
-func NewNginx(p string) (*Nginx, error) {
+func NewNginx(c Config) (*Nginx, error) {
+	// Ensure we only ever trust well formed addresses.
+	for _, p := range c.TrustedProxies {
+		if net.ParseIP(p) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(p); err != nil {
+			return &Nginx{}, errors.New(fmt.Sprintf("Invalid trusted proxy %s: %v", p, err))
+		}
+	}
+
 	// The template which will get used to expose Ingresses.
 	tmpl, err := template.New("nginx").Parse(tpl)
 	if err != nil {
@@ -112,14 +775,29 @@ func NewNginx(p string) (*Nginx, error) {
 	// Return the object so we can act upon it.
 	return &Nginx{
 		Template: tmpl,
-		Port:     p,
+		Config:   c,
+		Diffs:    &DiffLog{},
 		New: Backend{
-			Servers:   make(map[string][]Location),
-			Upstreams: make(map[string][]string),
+			Servers:    make(map[string]Server),
+			Upstreams:  make(map[string][]string),
+			RateLimits: make(map[string]RateLimit),
+			Canaries:   make(map[string]Canary),
+			Mirrors:    make(map[string]Mirror),
+			CORS:       make(map[string]CORS),
+			Backups:    make(map[string]BackupUpstream),
+			TCP:        make(map[string]Stream),
+			UDP:        make(map[string]Stream),
 		},
 		Prev: Backend{
-			Servers:   make(map[string][]Location),
-			Upstreams: make(map[string][]string),
+			Servers:    make(map[string]Server),
+			Upstreams:  make(map[string][]string),
+			RateLimits: make(map[string]RateLimit),
+			Canaries:   make(map[string]Canary),
+			Mirrors:    make(map[string]Mirror),
+			CORS:       make(map[string]CORS),
+			Backups:    make(map[string]BackupUpstream),
+			TCP:        make(map[string]Stream),
+			UDP:        make(map[string]Stream),
 		},
 	}, nil
 }
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -8,15 +13,17 @@ import (
 
 func TestReload(t *testing.T) {
 	b := Backend{
-		Servers: map[string][]Location{
-			"server1": []Location{
-				Location{
-					Path:     "/v1",
-					Upstream: "foo",
-				},
-				Location{
-					Path:     "/v2",
-					Upstream: "bar",
+		Servers: map[string]Server{
+			"server1": Server{
+				Locations: []Location{
+					Location{
+						Path:     "/v1",
+						Upstream: "foo",
+					},
+					Location{
+						Path:     "/v2",
+						Upstream: "bar",
+					},
 				},
 			},
 		},
@@ -38,3 +45,105 @@ func TestReload(t *testing.T) {
 	err := n.Reload()
 	assert.Equal(t, "Configuration has not changed. Not reloading the nginx daemon.", err.Error(), "Don't need to restart nginx")
 }
+
+func TestTrustedProxies(t *testing.T) {
+	_, err := NewNginx(Config{Port: "80", TrustedProxies: []string{"10.0.0.0/8", "not-a-cidr"}})
+	assert.NotNil(t, err, "Invalid proxy addresses are rejected")
+
+	n, err := NewNginx(Config{
+		Port:           "80",
+		RealIPHeader:   "X-Forwarded-For",
+		TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"},
+	})
+	assert.Nil(t, err)
+
+	var b bytes.Buffer
+	assert.Nil(t, n.Render(&b))
+	assert.Contains(t, b.String(), "set_real_ip_from  10.0.0.0/8;")
+	assert.Contains(t, b.String(), "set_real_ip_from  192.168.1.1;")
+	assert.NotContains(t, b.String(), "0.0.0.0/0")
+}
+
+func TestProxyProtocol(t *testing.T) {
+	n, err := NewNginx(Config{
+		Port:           "80",
+		RealIPHeader:   "X-Forwarded-For",
+		TrustedProxies: []string{"10.0.0.0/8"},
+		ProxyProtocol:  true,
+	})
+	assert.Nil(t, err)
+	n.SetServers(map[string]Server{
+		"example.com": Server{Locations: []Location{Location{Path: "/", Upstream: "foo"}}},
+	})
+
+	var b bytes.Buffer
+	assert.Nil(t, n.Render(&b))
+	assert.Contains(t, b.String(), "real_ip_header    proxy_protocol;")
+	assert.Contains(t, b.String(), "listen      80 proxy_protocol;")
+}
+
+func TestKeepalive(t *testing.T) {
+	n, err := NewNginx(Config{
+		Port:      "80",
+		Keepalive: Keepalive{Connections: 32, Requests: 100, Timeout: 60},
+	})
+	assert.Nil(t, err)
+	n.SetUpstreams(map[string][]string{
+		"foo": []string{"1.2.3.4:80"},
+	})
+
+	var b bytes.Buffer
+	assert.Nil(t, n.Render(&b))
+	assert.Contains(t, b.String(), "keepalive 32;")
+	assert.Contains(t, b.String(), "keepalive_requests 100;")
+	assert.Contains(t, b.String(), "keepalive_timeout 60s;")
+	assert.Contains(t, b.String(), `''      "";`)
+
+	n.Config.Keepalive = Keepalive{}
+	b.Reset()
+	assert.Nil(t, n.Render(&b))
+	assert.NotContains(t, b.String(), "keepalive")
+	assert.Contains(t, b.String(), `''      close;`)
+}
+
+func TestDynamicUpstreams(t *testing.T) {
+	var posted map[string]DynamicUpstream
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/configuration/upstreams", r.URL.Path)
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&posted))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer s.Close()
+
+	u, err := url.Parse(s.URL)
+	assert.Nil(t, err)
+
+	servers := map[string]Server{
+		"example.com": Server{Locations: []Location{Location{Path: "/", Upstream: "foo"}}},
+	}
+
+	n, err := NewNginx(Config{Port: "80", DynamicUpstreams: true, DynamicUpstreamsPort: u.Port()})
+	assert.Nil(t, err)
+	n.Prev = Backend{
+		Servers:   servers,
+		Upstreams: map[string][]string{"foo": []string{"1.2.3.4:80"}},
+	}
+	n.New = Backend{
+		Servers:   servers,
+		Upstreams: map[string][]string{"foo": []string{"1.2.3.4:80", "1.2.3.5:80"}},
+	}
+
+	// Only the servers changed so they are pushed without writing the configuration.
+	assert.Nil(t, n.Reload())
+	assert.Equal(t, map[string]DynamicUpstream{"foo": DynamicUpstream{Servers: []string{"1.2.3.4:80", "1.2.3.5:80"}}}, posted)
+	assert.Equal(t, n.New, n.Prev)
+
+	err = n.Reload()
+	assert.Equal(t, "Configuration has not changed. Not reloading the nginx daemon.", err.Error())
+
+	var b bytes.Buffer
+	assert.Nil(t, n.Render(&b))
+	assert.Contains(t, b.String(), `balancer.balance("foo")`)
+	assert.NotContains(t, b.String(), "1.2.3.4", "Servers are not written to the configuration")
+}
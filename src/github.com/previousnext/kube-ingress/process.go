@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Process supervises the nginx master process.
+type Process struct {
+	// Path to the nginx binary.
+	Binary string
+
+	// Configuration file nginx is started with.
+	Config string
+
+	// Restart nginx when it exits unexpectedly, otherwise the controller exits.
+	Restart bool
+
+	// Called when nginx has been restarted after exiting unexpectedly.
+	OnRestart func()
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	starts   int
+	stopping bool
+	exited   chan struct{}
+}
+
+// Returns true if the nginx master process is running.
+func (p *Process) Running() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.cmd != nil
+}
+
+// Returns how many times nginx has been started.
+func (p *Process) Starts() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.starts
+}
+
+// Starts nginx if it is not running yet, otherwise tells it to load the configuration again.
+func (p *Process) Reload() error {
+	if err := p.test(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopping {
+		return errors.New("nginx is stopping")
+	}
+
+	if p.cmd == nil {
+		return p.start()
+	}
+
+	// A HUP makes the master start new workers with the new configuration and gracefully stop the old ones.
+	return p.cmd.Process.Signal(syscall.SIGHUP)
+}
+
+// Sends a signal to the nginx master process.
+func (p *Process) Signal(sig os.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cmd == nil {
+		return errors.New("nginx is not running")
+	}
+	return p.cmd.Process.Signal(sig)
+}
+
+// Gracefully stops nginx, the same as "nginx -s quit", and waits for it to exit. Open connections
+// are closed if nginx has not stopped within the timeout.
+func (p *Process) Quit(timeout time.Duration) error {
+	p.mu.Lock()
+	p.stopping = true
+	cmd, exited := p.cmd, p.exited
+	p.mu.Unlock()
+
+	if cmd == nil {
+		return nil
+	}
+
+	// Workers finish serving the open requests before exiting.
+	if err := cmd.Process.Signal(syscall.SIGQUIT); err != nil {
+		return errors.New(fmt.Sprintf("Failed to stop nginx: %v", err))
+	}
+
+	select {
+	case <-exited:
+		return nil
+	case <-time.After(timeout):
+	}
+
+	// A TERM makes nginx stop without waiting for the open requests.
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		return errors.New(fmt.Sprintf("Failed to stop nginx: %v", err))
+	}
+	<-exited
+
+	return errors.New(fmt.Sprintf("nginx did not stop within %v, open connections were closed", timeout))
+}
+
+// Blocks until the controller is asked to terminate, forwarding any other signals to nginx.
+func (p *Process) WaitForTermination() os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGUSR1)
+
+	for sig := range signals {
+		switch sig {
+		case syscall.SIGTERM, syscall.SIGINT:
+			return sig
+		default:
+			if err := p.Signal(sig); err != nil {
+				fmt.Printf("Failed to forward %v: %v\n", sig, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Checks the configuration is valid, nginx keeps the old configuration if it isn't.
+func (p *Process) test() error {
+	out, err := exec.Command(p.Binary, "-t", "-c", p.Config).CombinedOutput()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Invalid nginx configuration: %v, err: %v", string(out), err))
+	}
+	return nil
+}
+
+// Starts the nginx master process in the foreground so it can be tracked, must be called with the lock held.
+func (p *Process) start() error {
+	cmd := exec.Command(p.Binary, "-c", p.Config, "-g", "daemon off;")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return errors.New(fmt.Sprintf("Failed to start nginx: %v", err))
+	}
+
+	fmt.Printf("Started nginx with pid %d\n", cmd.Process.Pid)
+
+	p.cmd = cmd
+	p.starts++
+	p.exited = make(chan struct{})
+
+	go p.wait(cmd, p.exited)
+
+	return nil
+}
+
+// Waits for nginx to exit, restarting it if it wasn't asked to stop.
+func (p *Process) wait(cmd *exec.Cmd, exited chan struct{}) {
+	err := cmd.Wait()
+
+	p.mu.Lock()
+	p.cmd = nil
+	stopping := p.stopping
+	p.mu.Unlock()
+
+	close(exited)
+
+	if stopping {
+		fmt.Println("nginx has stopped")
+		return
+	}
+
+	fmt.Printf("nginx exited unexpectedly: %v\n", err)
+
+	if !p.Restart {
+		os.Exit(1)
+	}
+
+	// Give whatever killed nginx a moment to pass before starting it again.
+	time.Sleep(time.Second)
+
+	p.mu.Lock()
+	if p.stopping {
+		p.mu.Unlock()
+		return
+	}
+	err = p.start()
+	p.mu.Unlock()
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if p.OnRestart != nil {
+		p.OnRestart()
+	}
+}
+
+// Standard method for loading a Process object.
+func NewProcess(binary, config string, restart bool) *Process {
+	return &Process{
+		Binary:  binary,
+		Config:  config,
+		Restart: restart,
+	}
+}
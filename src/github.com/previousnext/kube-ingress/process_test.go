@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Stands in for nginx, recording the signals it receives.
+const fakeNginx = `#!/bin/sh
+log="$(dirname "$0")/signals"
+if [ "$1" = "-t" ]; then
+  exit 0
+fi
+trap 'echo HUP >> "$log"' HUP
+trap 'echo QUIT >> "$log"; exit 0' QUIT
+while true; do
+  sleep 0.05
+done
+`
+
+func TestProcess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-ingress")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	binary := filepath.Join(dir, "nginx")
+	assert.Nil(t, ioutil.WriteFile(binary, []byte(fakeNginx), 0755))
+
+	p := NewProcess(binary, filepath.Join(dir, "nginx.conf"), false)
+	assert.False(t, p.Running())
+	assert.NotNil(t, p.Signal(syscall.SIGHUP), "Signals can't be sent before nginx is started")
+
+	// The first reload starts nginx.
+	assert.Nil(t, p.Reload())
+	assert.True(t, p.Running())
+	assert.Equal(t, 1, p.Starts())
+
+	// Give the shell a moment to install its traps.
+	time.Sleep(200 * time.Millisecond)
+
+	assert.Nil(t, p.Reload())
+	assert.Equal(t, 1, p.Starts(), "Reloads signal the running nginx")
+
+	assert.Nil(t, p.Quit(time.Second))
+	assert.False(t, p.Running())
+
+	signals, err := ioutil.ReadFile(filepath.Join(dir, "signals"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"HUP", "QUIT"}, strings.Fields(string(signals)))
+}
+
+func TestProcessInvalidConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-ingress")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	binary := filepath.Join(dir, "nginx")
+	assert.Nil(t, ioutil.WriteFile(binary, []byte("#!/bin/sh\necho bad config\nexit 1\n"), 0755))
+
+	p := NewProcess(binary, filepath.Join(dir, "nginx.conf"), false)
+	err = p.Reload()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "bad config")
+	assert.False(t, p.Running(), "nginx is not started with an invalid configuration")
+}
+
+func TestProcessQuitTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-ingress")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// Ignores the request to gracefully stop.
+	binary := filepath.Join(dir, "nginx")
+	assert.Nil(t, ioutil.WriteFile(binary, []byte("#!/bin/sh\n[ \"$1\" = -t ] && exit 0\ntrap '' QUIT\nwhile true; do sleep 0.05; done\n"), 0755))
+
+	p := NewProcess(binary, filepath.Join(dir, "nginx.conf"), false)
+	assert.Nil(t, p.Reload())
+	time.Sleep(200 * time.Millisecond)
+
+	err = p.Quit(200 * time.Millisecond)
+	assert.NotNil(t, err, "nginx is stopped when it doesn't quit in time")
+	assert.False(t, p.Running())
+	assert.NotNil(t, p.Reload(), "nginx isn't started again once stopping")
+}
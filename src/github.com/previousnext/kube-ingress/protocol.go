@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// Protocols which can be used to connect to a backend.
+const (
+	ProtocolHTTP  = "HTTP"
+	ProtocolHTTPS = "HTTPS"
+	ProtocolGRPC  = "GRPC"
+	ProtocolGRPCS = "GRPCS"
+)
+
+// UpstreamTLS configures how TLS connections to a backend are verified.
+type UpstreamTLS struct {
+	// Verify the certificate of the backend against the CA.
+	Verify bool
+	CAFile string
+
+	// Server name used for SNI and verification, defaults to the DNS name of the service.
+	Name string
+
+	// Checksum of the CA so changes trigger a reload.
+	Checksum string
+}
+
+// Loads the protocol used to connect to the backends of an ingress.
+func parseBackendProtocol(i extensions.Ingress) (string, error) {
+	v, ok := annotation(i, "backend-protocol")
+	if !ok {
+		return ProtocolHTTP, nil
+	}
+
+	switch p := strings.ToUpper(v); p {
+	case ProtocolHTTP, ProtocolHTTPS, ProtocolGRPC, ProtocolGRPCS:
+		return p, nil
+	}
+
+	return ProtocolHTTP, errors.New(fmt.Sprintf("Unsupported backend protocol: %s", v))
+}
+
+// Returns the settings for a service of the ingress, naming it after the service's DNS name when no name
+// was set, as the upstream name would never match the certificate of the backend.
+func (t *UpstreamTLS) forService(namespace, svc string) *UpstreamTLS {
+	if t == nil || t.Name != "" {
+		return t
+	}
+
+	c := *t
+	c.Name = fmt.Sprintf("%s.%s.svc", svc, namespace)
+	return &c
+}
+
+// Scheme used when passing requests to the upstream.
+func (l Location) Scheme() string {
+	if l.Protocol == "" {
+		return "http"
+	}
+	return strings.ToLower(l.Protocol)
+}
+
+// Returns true if requests are passed with the gRPC module.
+func (l Location) GRPC() bool {
+	return l.Protocol == ProtocolGRPC || l.Protocol == ProtocolGRPCS
+}
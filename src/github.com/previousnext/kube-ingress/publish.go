@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+// Component events are recorded as.
+const eventComponent = "kube-ingress"
+
+// Publisher writes the state of the ingresses back to the API. Only the leader publishes so the
+// replicas don't fight over it, while every replica still serves the ingresses.
+type Publisher struct {
+	Ingresses client.IngressNamespacer
+	Events    client.EventNamespacer
+
+	// Elects the replica which publishes, every replica publishes when this isn't set.
+	Leader *LeaderElector
+
+	// Addresses nginx is reachable on, written to the status of the ingresses.
+	Addresses []api.LoadBalancerIngress
+
+	// Never publish, used when only logging what would change.
+	DryRun bool
+}
+
+// Returns true if this replica should publish.
+func (p *Publisher) leading() bool {
+	return !p.DryRun && (p.Leader == nil || p.Leader.IsLeader())
+}
+
+// Records an event against an ingress.
+func (p *Publisher) Event(i extensions.Ingress, reason, format string, args ...interface{}) {
+	if !p.leading() {
+		return
+	}
+
+	now := unversioned.Now()
+
+	_, err := p.Events.Events(i.Namespace).Create(&api.Event{
+		ObjectMeta: api.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", i.Name, now.UnixNano()),
+			Namespace: i.Namespace,
+		},
+		InvolvedObject: api.ObjectReference{
+			Kind:            "Ingress",
+			APIVersion:      "extensions/v1beta1",
+			Namespace:       i.Namespace,
+			Name:            i.Name,
+			UID:             i.UID,
+			ResourceVersion: i.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        fmt.Sprintf(format, args...),
+		Source:         api.EventSource{Component: eventComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	})
+	if err != nil {
+		fmt.Printf("Failed to record event %s for ingress %s/%s: %v\n", reason, i.Namespace, i.Name, err)
+	}
+}
+
+// Records events for the ingresses which have been created, updated or deleted.
+func (p *Publisher) Changes(prev, ings []extensions.Ingress) {
+	existing := make(map[string]extensions.Ingress)
+	for _, i := range prev {
+		existing[MergeNameNameSpace(i.Namespace, i.Name)] = i
+	}
+
+	for _, i := range ings {
+		name := MergeNameNameSpace(i.Namespace, i.Name)
+
+		old, ok := existing[name]
+		delete(existing, name)
+
+		switch {
+		case !ok:
+			p.Event(i, "CREATE", "Ingress %s/%s", i.Namespace, i.Name)
+		case old.ResourceVersion != i.ResourceVersion && !reflect.DeepEqual(old.Spec, i.Spec):
+			p.Event(i, "UPDATE", "Ingress %s/%s", i.Namespace, i.Name)
+		}
+	}
+
+	for _, i := range existing {
+		p.Event(i, "DELETE", "Ingress %s/%s", i.Namespace, i.Name)
+	}
+}
+
+// Sets the addresses nginx is reachable on in the status of the ingresses.
+func (p *Publisher) UpdateStatus(ings []extensions.Ingress) {
+	if !p.leading() || len(p.Addresses) <= 0 {
+		return
+	}
+
+	for _, i := range ings {
+		if reflect.DeepEqual(i.Status.LoadBalancer.Ingress, p.Addresses) {
+			continue
+		}
+
+		i.Status.LoadBalancer.Ingress = p.Addresses
+
+		if _, err := p.Ingresses.Ingress(i.Namespace).UpdateStatus(&i); err != nil {
+			fmt.Printf("Failed to update the status of ingress %s/%s: %v\n", i.Namespace, i.Name, err)
+			continue
+		}
+
+		fmt.Printf("Updated the status of ingress %s/%s\n", i.Namespace, i.Name)
+	}
+}
+
+// Helper to build the load balancer addresses from a list of IPs and hostnames.
+func loadBalancerIngress(addrs []string) []api.LoadBalancerIngress {
+	var l []api.LoadBalancerIngress
+
+	for _, a := range addrs {
+		if net.ParseIP(a) != nil {
+			l = append(l, api.LoadBalancerIngress{IP: a})
+			continue
+		}
+		l = append(l, api.LoadBalancerIngress{Hostname: a})
+	}
+
+	return l
+}
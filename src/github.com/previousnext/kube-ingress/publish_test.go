@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/unversioned/testclient"
+)
+
+// Helper to list the actions a fake client received as verb/resource.
+func testActions(f *testclient.Fake) []string {
+	var l []string
+	for _, a := range f.Actions() {
+		l = append(l, a.GetVerb()+"/"+a.GetResource())
+	}
+	return l
+}
+
+func TestPublisherUpdateStatus(t *testing.T) {
+	var (
+		current = testIngress("default", "current", "current.com", "/", "foo", nil)
+		stale   = testIngress("default", "stale", "stale.com", "/", "foo", nil)
+		addrs   = loadBalancerIngress([]string{"1.2.3.4", "lb.example.com"})
+	)
+
+	assert.Equal(t, []api.LoadBalancerIngress{{IP: "1.2.3.4"}, {Hostname: "lb.example.com"}}, addrs)
+	current.Status.LoadBalancer.Ingress = addrs
+
+	f := testclient.NewSimpleFake(&current, &stale)
+	p := &Publisher{Ingresses: f.Extensions(), Events: f, Addresses: addrs}
+
+	p.UpdateStatus([]extensions.Ingress{current, stale})
+	assert.Equal(t, []string{"update/ingresses"}, testActions(f), "Only ingresses with a different status are updated")
+	assert.Equal(t, addrs, f.Actions()[0].(testclient.UpdateAction).GetObject().(*extensions.Ingress).Status.LoadBalancer.Ingress)
+
+	// Followers leave the status to the leader.
+	f.ClearActions()
+	p.Leader = &LeaderElector{}
+	p.UpdateStatus([]extensions.Ingress{current, stale})
+	assert.Empty(t, testActions(f))
+
+	p.Leader.leader = true
+	p.UpdateStatus([]extensions.Ingress{current, stale})
+	assert.Equal(t, []string{"update/ingresses"}, testActions(f))
+}
+
+func TestPublisherChanges(t *testing.T) {
+	var (
+		kept    = testIngress("default", "kept", "kept.com", "/", "foo", nil)
+		updated = testIngress("default", "updated", "updated.com", "/", "foo", nil)
+		deleted = testIngress("default", "deleted", "deleted.com", "/", "foo", nil)
+		created = testIngress("default", "created", "created.com", "/", "foo", nil)
+	)
+
+	f := testclient.NewSimpleFake()
+	p := &Publisher{Ingresses: f.Extensions(), Events: f, Leader: &LeaderElector{leader: true}}
+
+	changed := testIngress("default", "updated", "changed.com", "/", "foo", nil)
+	changed.ResourceVersion = "2"
+
+	p.Changes([]extensions.Ingress{kept, updated, deleted}, []extensions.Ingress{kept, changed, created})
+
+	var reasons []string
+	for _, a := range f.Actions() {
+		e := a.(testclient.CreateAction).GetObject().(*api.Event)
+		assert.Equal(t, "Ingress", e.InvolvedObject.Kind)
+		reasons = append(reasons, e.Reason+" "+e.InvolvedObject.Name)
+	}
+	assert.Equal(t, []string{"UPDATE updated", "CREATE created", "DELETE deleted"}, reasons)
+
+	// Followers don't record events.
+	f.ClearActions()
+	p.Leader.leader = false
+	p.Changes([]extensions.Ingress{kept}, []extensions.Ingress{created})
+	assert.Empty(t, testActions(f))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// RateLimit throttles the requests and connections per client address for an ingress.
+type RateLimit struct {
+	// Name of the zones, derived from the ingress so it is stable between reloads.
+	Zone string
+
+	RPS         int
+	RPM         int
+	Burst       int
+	Connections int
+
+	// Client CIDRs which are exempt from the limits.
+	Whitelist []string
+}
+
+// Loads the rate limits for an ingress, returns nil if none were requested.
+func parseRateLimit(i extensions.Ingress) (*RateLimit, error) {
+	var (
+		rl  = &RateLimit{Zone: zoneName(i.ObjectMeta.Namespace, i.ObjectMeta.Name)}
+		err error
+	)
+
+	if rl.RPS, err = annotationInt(i, "limit-rps", 0); err != nil {
+		return nil, err
+	}
+	if rl.RPM, err = annotationInt(i, "limit-rpm", 0); err != nil {
+		return nil, err
+	}
+	if rl.Burst, err = annotationInt(i, "limit-burst", 0); err != nil {
+		return nil, err
+	}
+	if rl.Connections, err = annotationInt(i, "limit-connections", 0); err != nil {
+		return nil, err
+	}
+
+	if rl.RPS == 0 && rl.RPM == 0 && rl.Connections == 0 {
+		return nil, nil
+	}
+
+	for _, c := range annotationList(i, "limit-whitelist") {
+		if net.ParseIP(c) == nil {
+			if _, _, err := net.ParseCIDR(c); err != nil {
+				return nil, errors.New(fmt.Sprintf("Invalid whitelist entry %s: %v", c, err))
+			}
+		}
+		rl.Whitelist = append(rl.Whitelist, c)
+	}
+
+	return rl, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// Redirect answers the requests for a location with a redirect instead of proxying them.
+type Redirect struct {
+	URL  string
+	Code int
+}
+
+// Loads the redirect of an ingress, returns nil if requests are proxied.
+func parseRedirect(i extensions.Ingress) (*Redirect, error) {
+	if u, ok := annotation(i, "permanent-redirect"); ok {
+		return newRedirect(i, u, "permanent-redirect-code", 301, []int{301, 308})
+	}
+	if u, ok := annotation(i, "temporal-redirect"); ok {
+		return newRedirect(i, u, "temporal-redirect-code", 302, []int{302, 303, 307})
+	}
+	return nil, nil
+}
+
+// Helper to validate the target and code of a redirect.
+func newRedirect(i extensions.Ingress, target, key string, def int, allowed []int) (*Redirect, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme == "" || u.Host == "" || strings.ContainsAny(target, " \t\n;\"'{}") {
+		return nil, errors.New(fmt.Sprintf("Invalid redirect URL: %s", target))
+	}
+
+	code, err := annotationInt(i, key, def)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range allowed {
+		if c == code {
+			return &Redirect{URL: target, Code: code}, nil
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("Invalid redirect code: %d", code))
+}
+
+// Helper to get the www. or bare version of a host.
+func wwwAlias(host string) string {
+	if strings.HasPrefix(host, "www.") {
+		return strings.TrimPrefix(host, "www.")
+	}
+	return "www." + host
+}
+
+// Adds servers redirecting the www. or bare version of hosts which requested it,
+// unless that host is routed by an ingress.
+func (bu *Builder) addWWWRedirects(b *Backend) {
+	for host, server := range b.Servers {
+		if !server.FromToWWW || host == "" || server.RedirectTo != "" {
+			continue
+		}
+
+		alias := wwwAlias(host)
+		if _, ok := b.Servers[alias]; ok {
+			continue
+		}
+
+		b.Servers[alias] = Server{
+			RedirectTo: host,
+			SSLCert:    server.SSLCert,
+		}
+	}
+}
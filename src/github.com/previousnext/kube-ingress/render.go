@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Returns a builder which resolves the services from the endpoints in the manifests instead of a cluster.
+// The builder is quiet, the commands decide how to output the problems it finds.
+func manifestBuilder(cfg Config, m Manifests) *Builder {
+	bu := &Builder{
+		Config:   cfg,
+		Services: &Services{},
+		Secrets:  &Secrets{Dir: *cliSSLDir, DryRun: true},
+		Quiet:    true,
+	}
+	bu.Services.LoadEndpoints(m.Services, m.Endpoints)
+	bu.Secrets.Load(m.Secrets)
+
+	return bu
+}
+
+// Builds the backend for the ingresses in the manifests, along with the problems found.
+func RenderBackend(cfg Config, m Manifests, tcp, udp map[string]string) (Backend, []Problem) {
+	bu := manifestBuilder(cfg, m)
+	bu.TCPServices = tcp
+	bu.UDPServices = udp
+
+	b := bu.Build(m.Ingresses)
+	return b, bu.Problems
+}
+
+// Writes the nginx configuration, or the backend as JSON.
+func RenderOutput(w io.Writer, cfg Config, b Backend, output string) error {
+	if output == "json" {
+		data, err := json.MarshalIndent(b, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+
+	n, err := NewNginx(cfg)
+	if err != nil {
+		return err
+	}
+	n.SetBackend(b)
+
+	return n.Render(w)
+}
+
+// Runs the render command, returning the exit code.
+func render() int {
+	cfg, err := flagConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	m, err := LoadManifests(*cliRenderFiles)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	tcp, err := LoadStreamServices(*cliTCPServices)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	udp, err := LoadStreamServices(*cliUDPServices)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Only the output goes to stdout, the problems found along the way go to stderr.
+	b, problems := RenderBackend(cfg, m, tcp, udp)
+	for _, p := range problems {
+		fmt.Fprintln(os.Stderr, p.Message)
+	}
+
+	if err := RenderOutput(os.Stdout, cfg, b, *cliRenderOutput); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadManifests(t *testing.T) {
+	m, err := LoadManifests([]string{"testdata/ingresses.yaml", "testdata/services.yaml", "testdata/endpoints.yaml"})
+	assert.Nil(t, err)
+	assert.Len(t, m.Ingresses, 3)
+	assert.Len(t, m.Services, 3, "Items of lists are loaded")
+	assert.Len(t, m.Endpoints, 3)
+	assert.Len(t, m.Secrets, 1)
+	assert.Equal(t, "default", m.Ingresses[0].Namespace, "Objects default to the default namespace")
+	assert.Equal(t, "backend", m.Ingresses[2].Namespace)
+
+	dir, err := ioutil.TempDir("", "kube-ingress")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	invalid := filepath.Join(dir, "invalid.yaml")
+	assert.Nil(t, ioutil.WriteFile(invalid, []byte("kind: Service\napiVersion: v1\n---\nkind: Nope\napiVersion: v1\n"), 0644))
+	_, err = LoadManifests([]string{invalid})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), invalid+":4:", "Errors point at the document")
+}
+
+func TestRender(t *testing.T) {
+	m, err := LoadManifests([]string{"testdata/ingresses.yaml", "testdata/services.yaml", "testdata/endpoints.yaml"})
+	assert.Nil(t, err)
+
+	cfg := Config{Port: "80", SSLPort: "443", SSLRedirect: true}
+	b, problems := RenderBackend(cfg, m, nil, nil)
+	assert.Empty(t, problems)
+
+	assert.Equal(t, []string{"10.0.0.1:8080", "10.0.0.2:8080"}, b.Upstreams["default-web"], "The pods are served on the target port")
+	assert.Equal(t, []string{"10.0.2.1:8080"}, b.Upstreams["backend-api"])
+	assert.Equal(t, "backend-api", b.Servers["api.example.com"].Locations[0].Upstream)
+	assert.NotNil(t, b.Servers["example.com"].Locations[0].Canary)
+	assert.NotNil(t, b.Servers["example.com"].SSLCert, "Certificates are loaded from the secrets")
+
+	var out bytes.Buffer
+	assert.Nil(t, RenderOutput(&out, cfg, b, "nginx"))
+	assert.Contains(t, out.String(), "server_name api.example.com;")
+	assert.Contains(t, out.String(), "10% default-web-v2;")
+
+	out.Reset()
+	assert.Nil(t, RenderOutput(&out, cfg, b, "json"))
+
+	var decoded Backend
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, b, decoded)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// Conditions which can be used to pass a request on to the next upstream server.
+var nextUpstreamConditions = map[string]bool{
+	"error":          true,
+	"timeout":        true,
+	"invalid_header": true,
+	"http_500":       true,
+	"http_502":       true,
+	"http_503":       true,
+	"http_504":       true,
+	"http_403":       true,
+	"http_404":       true,
+	"http_429":       true,
+	"non_idempotent": true,
+	"off":            true,
+}
+
+// HealthCheck marks upstream servers as down after failing requests.
+type HealthCheck struct {
+	// Failed requests within the FailTimeout before a server is marked as down.
+	MaxFails int
+
+	// Seconds the failures are counted over, and the server is then marked down for.
+	FailTimeout int
+}
+
+// NextUpstream decides when a failed request is retried on another upstream server.
+type NextUpstream struct {
+	// Space separated list of conditions, non-idempotent requests are only retried
+	// when "non_idempotent" is included.
+	Conditions string
+
+	// Maximum attempts and seconds spent passing the request on, 0 for no limit.
+	Tries   int
+	Timeout int
+}
+
+// BackupUpstream combines the servers of an upstream with those of a backup service
+// which only receives requests when all the primary servers are down.
+type BackupUpstream struct {
+	Primary string
+	Backup  string
+}
+
+// Loads the retry policy of an ingress, falling back to the global configuration.
+func parseNextUpstream(i extensions.Ingress, c Config) (NextUpstream, error) {
+	var (
+		n   = c.NextUpstream
+		err error
+	)
+
+	if v, ok := annotation(i, "proxy-next-upstream"); ok {
+		if n.Conditions, err = parseNextUpstreamConditions(v); err != nil {
+			return c.NextUpstream, err
+		}
+	}
+
+	if n.Tries, err = annotationInt(i, "proxy-next-upstream-tries", n.Tries); err != nil {
+		return c.NextUpstream, err
+	}
+	if n.Timeout, err = annotationInt(i, "proxy-next-upstream-timeout", n.Timeout); err != nil {
+		return c.NextUpstream, err
+	}
+
+	return n, nil
+}
+
+// Helper to validate a space separated list of next upstream conditions.
+func parseNextUpstreamConditions(v string) (string, error) {
+	for _, cond := range strings.Fields(v) {
+		if !nextUpstreamConditions[cond] {
+			return "", errors.New(fmt.Sprintf("Invalid next upstream condition: %s", cond))
+		}
+	}
+	return strings.Join(strings.Fields(v), " "), nil
+}
+
+// Helper to get the name of the upstream combining a primary and backup upstream. Service
+// upstreams don't contain underscores so this cannot collide with them.
+func backupUpstreamName(primary, backup string) string {
+	return primary + "_backup_" + backup
+}
+
+// Resolves the upstream for a port of the service of a path, falling back to the backup service of
+// the ingress.
+func (bu *Builder) upstream(b *Backend, i extensions.Ingress, svc string, port int) (string, error) {
+	name, list, err := bu.Services.Upstream(MergeNameNameSpace(i.ObjectMeta.Namespace, svc), port)
+
+	backup, ok := annotation(i, "backup-service")
+	if !ok {
+		if err != nil {
+			return "", err
+		}
+		b.Upstreams[name] = list
+		return name, nil
+	}
+
+	backupName := MergeNameNameSpace(i.ObjectMeta.Namespace, backup)
+
+	backupList, backupErr := bu.Services.Get(backupName)
+	if backupErr != nil {
+		bu.report(i, SeverityWarning, "service", "Backup service for ingress %s/%s is not available: %s", i.ObjectMeta.Namespace, i.ObjectMeta.Name, backupErr)
+		if err != nil {
+			return "", err
+		}
+		b.Upstreams[name] = list
+		return name, nil
+	}
+	b.Upstreams[backupName] = backupList
+
+	// When the primary service has no pods the backup serves all the requests.
+	if err != nil {
+		bu.logf("Using the backup service %s: %s\n", backupName, err)
+		return backupName, nil
+	}
+	b.Upstreams[name] = list
+
+	combined := backupUpstreamName(name, backupName)
+	b.Backups[combined] = BackupUpstream{
+		Primary: name,
+		Backup:  backupName,
+	}
+
+	return combined, nil
+}
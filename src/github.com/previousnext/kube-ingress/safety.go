@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var syncRefused = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "kube_ingress_sync_refused_total",
+	Help: "Number of syncs refused because they dropped too many routes.",
+})
+
+func init() {
+	prometheus.MustRegister(syncRefused)
+}
+
+// Returns the routes served by a backend, the paths of every host along with the streams.
+func routes(b Backend) map[string]bool {
+	l := make(map[string]bool)
+
+	for host, server := range b.Servers {
+		for _, location := range server.Locations {
+			l[host+location.Path] = true
+		}
+	}
+	for port := range b.TCP {
+		l["tcp:"+port] = true
+	}
+	for port := range b.UDP {
+		l["udp:"+port] = true
+	}
+
+	return l
+}
+
+// Returns the percentage of the routes of the previous backend which the next one drops.
+func droppedRoutes(prev, next Backend) int {
+	var (
+		before  = routes(prev)
+		after   = routes(next)
+		dropped int
+	)
+
+	if len(before) <= 0 {
+		return 0
+	}
+
+	for r := range before {
+		if !after[r] {
+			dropped++
+		}
+	}
+
+	return dropped * 100 / len(before)
+}
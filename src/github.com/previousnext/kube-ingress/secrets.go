@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/util"
+)
+
+// Keys of a secret which hold the certificate data.
+const (
+	secretCert = "tls.crt"
+	secretKey  = "tls.key"
+	secretCA   = "ca.crt"
+)
+
+// SSLCert is a certificate which is written to disk for nginx to load.
+type SSLCert struct {
+	// File containing the certificate and private key.
+	PemFile string
+
+	// File containing a certificate authority, used to verify upstreams.
+	CAFile string
+
+	// Checksum of the data so changes trigger a reload even though the files are the same.
+	Checksum string
+}
+
+type Secrets struct {
+	Client *client.Client
+	Dir    string
+	List   map[string]SSLCert
+
+	// Contents of the certificate files, only the files used by the backend are written.
+	files map[string][]byte
+
+	// Only work out where the certificates would be written without writing them.
+	DryRun bool
+
+	// Called when the list of certificates has changed.
+	OnChange func()
+
+	// Set once the certificates have been loaded for the first time.
+	synced bool
+}
+
+func (s *Secrets) Start(ctx context.Context) {
+	rl := util.NewTokenBucketRateLimiter(0.1, 1)
+
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		fmt.Printf("Failed to create the certificate directory: %v\n", err)
+	}
+
+	for {
+		if !accept(ctx, rl) {
+			return
+		}
+
+		secrets, err := s.Client.Secrets("").List(labels.Everything(), fields.Everything())
+		if err != nil {
+			fmt.Printf("Error retrieving secrets: %v\n", err)
+			continue
+		}
+
+		changed := s.Load(secrets.Items)
+
+		if changed && s.OnChange != nil {
+			s.OnChange()
+		}
+	}
+}
+
+// Loads the certificates from a list of secrets, returns true if they have changed.
+func (s *Secrets) Load(secrets []api.Secret) bool {
+	// We build a fresh list every time to ensure we don't have any issues with old data.
+	var (
+		newSecrets = make(map[string]SSLCert)
+		newFiles   = make(map[string][]byte)
+	)
+
+	for _, secret := range secrets {
+		name := MergeNameNameSpace(secret.ObjectMeta.Namespace, secret.ObjectMeta.Name)
+
+		// Secrets which don't hold any certificates are skipped.
+		cert := s.certificate(name, secret.Data, newFiles)
+		if cert == nil {
+			continue
+		}
+
+		newSecrets[name] = *cert
+	}
+
+	changed := !s.synced || !reflect.DeepEqual(newSecrets, s.List)
+
+	// Now that we have built the list we can hand it over so be used for Get() requests.
+	s.List = newSecrets
+	s.files = newFiles
+	s.synced = true
+
+	return changed
+}
+
+// Works out the files holding the certificate data of a secret and adds their contents to the
+// list of files, returns nil if the secret does not hold any.
+func (s *Secrets) certificate(name string, data map[string][]byte, files map[string][]byte) *SSLCert {
+	var (
+		cert = &SSLCert{}
+		sum  = sha1.New()
+	)
+
+	if len(data[secretCert]) > 0 && len(data[secretKey]) > 0 {
+		pem := bytes.Join([][]byte{data[secretCert], data[secretKey]}, []byte("\n"))
+		cert.PemFile = filepath.Join(s.Dir, name+".pem")
+		files[cert.PemFile] = pem
+		sum.Write(pem)
+	}
+
+	if len(data[secretCA]) > 0 {
+		cert.CAFile = filepath.Join(s.Dir, name+"-ca.crt")
+		files[cert.CAFile] = data[secretCA]
+		sum.Write(data[secretCA])
+	}
+
+	if cert.PemFile == "" && cert.CAFile == "" {
+		return nil
+	}
+
+	cert.Checksum = fmt.Sprintf("%x", sum.Sum(nil))
+	return cert
+}
+
+// Writes the certificates used by a backend, so nginx only gets the secrets ingresses reference.
+func (s *Secrets) Write(b Backend) error {
+	if s.DryRun {
+		return nil
+	}
+
+	for _, f := range certificateFiles(b) {
+		// Files which didn't come from the secrets, eg. of a snapshot, are left as they are.
+		data, ok := s.files[f]
+		if !ok {
+			continue
+		}
+		if err := writeIfChanged(f, data); err != nil {
+			return errors.New(fmt.Sprintf("Failed to write certificate %s: %v", f, err))
+		}
+	}
+
+	return nil
+}
+
+// Removes the certificates which are no longer used by a backend, once nginx has been reloaded
+// without them.
+func (s *Secrets) RemoveStale(b Backend) {
+	if s.DryRun || s.Dir == "" {
+		return
+	}
+
+	used := make(map[string]bool)
+	for _, f := range certificateFiles(b) {
+		used[f] = true
+	}
+
+	files, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		fmt.Printf("Failed to list the certificates: %v\n", err)
+		return
+	}
+
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || name == defaultCertFile || !(strings.HasSuffix(name, ".pem") || strings.HasSuffix(name, "-ca.crt")) {
+			continue
+		}
+
+		path := filepath.Join(s.Dir, name)
+		if used[path] {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			fmt.Printf("Failed to remove the certificate %s: %v\n", path, err)
+			continue
+		}
+		fmt.Printf("Removed the unused certificate %s\n", path)
+	}
+}
+
+// Returns the certificate files referenced by a backend.
+func certificateFiles(b Backend) []string {
+	var l []string
+
+	add := func(f string) {
+		if f != "" {
+			l = append(l, f)
+		}
+	}
+
+	for _, server := range b.Servers {
+		if server.SSLCert != nil {
+			add(server.SSLCert.PemFile)
+			add(server.SSLCert.CAFile)
+		}
+		for _, location := range server.Locations {
+			if location.UpstreamTLS != nil {
+				add(location.UpstreamTLS.CAFile)
+			}
+		}
+	}
+
+	return l
+}
+
+// Returns true once the certificates have been loaded from the API.
+func (s *Secrets) Synced() bool {
+	return s.synced
+}
+
+func (s *Secrets) Get(n string) (SSLCert, error) {
+	if val, ok := s.List[n]; ok {
+		return val, nil
+	}
+	return SSLCert{}, errors.New(fmt.Sprintf("Cannot find the certificate: %s", n))
+}
+
+// Helper to only write a file when the contents have changed.
+func writeIfChanged(path string, data []byte) error {
+	if existing, err := ioutil.ReadFile(path); err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// Standard method for loading a Secrets object.
+func NewSecrets(ctx context.Context, c *client.Client, dir string, onChange func()) *Secrets {
+	s := &Secrets{
+		Client:   c,
+		Dir:      dir,
+		List:     make(map[string]SSLCert),
+		files:    make(map[string][]byte),
+		OnChange: onChange,
+	}
+
+	// Start the continual process of pulling the certificates.
+	go s.Start(ctx)
+
+	// Return the object so we can query it.
+	return s
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-ingress")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	secret := func(name string) api.Secret {
+		return api.Secret{
+			ObjectMeta: api.ObjectMeta{Namespace: "default", Name: name},
+			Data: map[string][]byte{
+				secretCert: []byte("cert"),
+				secretKey:  []byte("key"),
+			},
+		}
+	}
+
+	s := &Secrets{Dir: dir}
+	assert.True(t, s.Load([]api.Secret{secret("web-tls"), secret("unused-tls"), api.Secret{}}))
+	assert.Len(t, s.List, 2, "Secrets without certificates are skipped")
+
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Empty(t, files, "Loading the secrets doesn't write them")
+
+	// Files of certificates which are no longer used, and the default certificate.
+	stale := filepath.Join(dir, "default-old-tls.pem")
+	assert.Nil(t, ioutil.WriteFile(stale, []byte("old"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, defaultCertFile), []byte("default"), 0600))
+
+	cert, err := s.Get("default-web-tls")
+	assert.Nil(t, err)
+
+	b := Backend{Servers: map[string]Server{"example.com": Server{SSLCert: &cert}}}
+	assert.Nil(t, s.Write(b))
+	s.RemoveStale(b)
+
+	data, err := ioutil.ReadFile(cert.PemFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "cert\nkey", string(data))
+
+	files, err = ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 2, "Only the certificates used by the backend are kept")
+	_, err = os.Stat(stale)
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = s.Get("default-missing")
+	assert.Equal(t, "Cannot find the certificate: default-missing", err.Error())
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"reflect"
 
 	"k8s.io/kubernetes/pkg/api"
 	client "k8s.io/kubernetes/pkg/client/unversioned"
@@ -14,13 +16,27 @@ import (
 type Services struct {
 	Client *client.Client
 	List   map[string][]string
+
+	// Addresses of the running pods keyed by the service port they are exposed on.
+	Ports map[string]map[int][]string
+
+	// Ports of the services which ingresses can route to by number or name.
+	ServicePorts map[string][]api.ServicePort
+
+	// Called when the list of services or their pods has changed.
+	OnChange func()
+
+	// Set once the services have been loaded for the first time.
+	synced bool
 }
 
-func (s *Services) Start() {
+func (s *Services) Start(ctx context.Context) {
 	rl := util.NewTokenBucketRateLimiter(0.1, 1)
 
 	for {
-		rl.Accept()
+		if !accept(ctx, rl) {
+			return
+		}
 
 		// First we need to load the services.
 		svcs, err := s.Client.Services("").List(labels.Everything())
@@ -30,20 +46,32 @@ func (s *Services) Start() {
 		}
 
 		// We build a fresh list every time to ensure we don't have any issues with old data.
-		newSvcs := make(map[string][]string)
+		var (
+			newSvcs     = make(map[string][]string)
+			newPorts    = make(map[string]map[int][]string)
+			newSvcPorts = make(map[string][]api.ServicePort)
+		)
 
 		// Now we go over all the services and associate the pod IP addresses
 		// to each of the services.
 		for _, svc := range svcs.Items {
 			var addrs []string
 
+			name := MergeNameNameSpace(svc.ObjectMeta.Namespace, svc.ObjectMeta.Name)
+			newSvcPorts[name] = svc.Spec.Ports
+
 			ps, err := s.Client.Pods(svc.ObjectMeta.Namespace).List(labels.SelectorFromSet(labels.Set(svc.Spec.Selector)), fields.Everything())
 			if err != nil {
-				fmt.Printf("Error retrieving service: %v\n", err)
+				// A failed lookup doesn't mean the pods are gone, so we keep the ones we knew about.
+				fmt.Printf("Error retrieving service, keeping the previous pods: %v\n", err)
+				if val, ok := s.List[name]; ok {
+					newSvcs[name] = val
+					newPorts[name] = s.Ports[name]
+				}
 				continue
 			}
 
-			name := MergeNameNameSpace(svc.ObjectMeta.Namespace, svc.ObjectMeta.Name)
+			ports := make(map[int][]string)
 
 			// Add all the running pods to the list.
 			for _, p := range ps.Items {
@@ -51,8 +79,16 @@ func (s *Services) Start() {
 					fmt.Printf("Skipping pod %s for service %s\n", p.Name, name)
 					continue
 				}
-				fmt.Printf("Added pod %s for service %s\n", p.Name, name)
-				addrs = append(addrs, p.Status.PodIP+":80")
+				if tp := httpTargetPort(svc, p); tp > 0 {
+					fmt.Printf("Added pod %s for service %s\n", p.Name, name)
+					addrs = append(addrs, fmt.Sprintf("%s:%d", p.Status.PodIP, tp))
+				}
+
+				for _, sp := range svc.Spec.Ports {
+					if tp := targetPort(sp, p); tp > 0 {
+						ports[sp.Port] = append(ports[sp.Port], fmt.Sprintf("%s:%d", p.Status.PodIP, tp))
+					}
+				}
 			}
 
 			// Ensure we have some addresses, if we don't, we don't have to
@@ -64,30 +100,208 @@ func (s *Services) Start() {
 
 			fmt.Printf("Added the service: %v\n", name)
 			newSvcs[name] = addrs
+			newPorts[name] = ports
 		}
 
+		changed := !s.synced || !reflect.DeepEqual(newSvcs, s.List) || !reflect.DeepEqual(newPorts, s.Ports) || !reflect.DeepEqual(newSvcPorts, s.ServicePorts)
+
 		// Now that we have built the list we can hand it over so be used for Get() requests.
 		s.List = newSvcs
+		s.Ports = newPorts
+		s.ServicePorts = newSvcPorts
+		s.synced = true
+
+		if changed && s.OnChange != nil {
+			s.OnChange()
+		}
 	}
 }
 
+// Loads the services from their endpoints instead of the pods, used when there is no API
+// to query.
+func (s *Services) LoadEndpoints(svcs []api.Service, eps []api.Endpoints) {
+	endpoints := make(map[string]api.Endpoints)
+	for _, e := range eps {
+		endpoints[MergeNameNameSpace(e.ObjectMeta.Namespace, e.ObjectMeta.Name)] = e
+	}
+
+	var (
+		newSvcs     = make(map[string][]string)
+		newPorts    = make(map[string]map[int][]string)
+		newSvcPorts = make(map[string][]api.ServicePort)
+	)
+
+	for _, svc := range svcs {
+		var (
+			name  = MergeNameNameSpace(svc.ObjectMeta.Namespace, svc.ObjectMeta.Name)
+			addrs []string
+			ports = make(map[int][]string)
+		)
+
+		newSvcPorts[name] = svc.Spec.Ports
+
+		for _, subset := range endpoints[name].Subsets {
+			for _, a := range subset.Addresses {
+				if p := httpEndpointPort(svc, subset); p > 0 {
+					addrs = append(addrs, fmt.Sprintf("%s:%d", a.IP, p))
+				}
+
+				// The ports of the endpoints are the target ports, named after the service ports.
+				for _, sp := range svc.Spec.Ports {
+					for _, ep := range subset.Ports {
+						if ep.Name == sp.Name {
+							ports[sp.Port] = append(ports[sp.Port], fmt.Sprintf("%s:%d", a.IP, ep.Port))
+						}
+					}
+				}
+			}
+		}
+
+		// Services without any endpoints are reported by the builder when they are used.
+		if len(addrs) <= 0 {
+			continue
+		}
+
+		newSvcs[name] = addrs
+		newPorts[name] = ports
+	}
+
+	s.List = newSvcs
+	s.Ports = newPorts
+	s.ServicePorts = newSvcPorts
+	s.synced = true
+}
+
+// Returns true once the services have been loaded from the API.
+func (s *Services) Synced() bool {
+	return s.synced
+}
+
 func (s *Services) Get(n string) ([]string, error) {
 	if val, ok := s.List[n]; ok {
 		return val, nil
 	}
-	return []string{}, errors.New(fmt.Sprintf("Cannot find the service: %s\n", n))
+	return []string{}, errors.New(fmt.Sprintf("Cannot find the service: %s", n))
+}
+
+// Returns the addresses of the pods behind a port of the service.
+func (s *Services) GetPort(n string, port int) ([]string, error) {
+	if val, ok := s.Ports[n][port]; ok {
+		return val, nil
+	}
+	return []string{}, errors.New(fmt.Sprintf("Cannot find the service port: %s:%d", n, port))
+}
+
+// Resolves the service port an ingress routes to by its number or name, 0 if the ingress doesn't
+// set one. The ports of services which have not been loaded are returned as they are.
+func (s *Services) ResolvePort(n string, port util.IntOrString) (int, error) {
+	sps := s.ServicePorts[n]
+	if len(sps) == 0 || (port.Kind == util.IntstrInt && port.IntVal == 0) {
+		return port.IntVal, nil
+	}
+
+	for _, sp := range sps {
+		if port.Kind == util.IntstrInt && sp.Port == port.IntVal {
+			return sp.Port, nil
+		}
+		if port.Kind == util.IntstrString && sp.Name == port.StrVal {
+			return sp.Port, nil
+		}
+	}
+
+	return 0, errors.New(fmt.Sprintf("Cannot find the service port: %s:%s", n, port.String()))
+}
+
+// Returns the name and addresses of the upstream for a port of a service. The port the service is
+// listed with keeps the name of the service, its other ports get an upstream of their own.
+func (s *Services) Upstream(n string, port int) (string, []string, error) {
+	list, err := s.Get(n)
+	if err != nil {
+		return n, list, err
+	}
+
+	if sp, ok := httpServicePort(s.ServicePorts[n]); !ok || port == 0 || sp.Port == port {
+		return n, list, nil
+	}
+
+	list, err = s.GetPort(n, port)
+	return fmt.Sprintf("%s-%d", n, port), list, err
+}
+
+// Helper to find the service port the services are listed with, port 80 if the service has it
+// or otherwise its first port.
+func httpServicePort(l []api.ServicePort) (api.ServicePort, bool) {
+	for _, sp := range l {
+		if sp.Port == 80 {
+			return sp, true
+		}
+	}
+	if len(l) > 0 {
+		return l[0], true
+	}
+	return api.ServicePort{}, false
+}
+
+// Helper to resolve the port on the pod which receives the requests of the ingresses, returns
+// 0 if the pod does not expose it. Services without any ports are served on port 80.
+func httpTargetPort(svc api.Service, p api.Pod) int {
+	sp, ok := httpServicePort(svc.Spec.Ports)
+	if !ok {
+		return 80
+	}
+	return targetPort(sp, p)
+}
+
+// Helper to resolve the port of the endpoints which receives the requests of the ingresses,
+// returns 0 if the endpoints don't expose it. The ports of the endpoints are named after the
+// service ports.
+func httpEndpointPort(svc api.Service, subset api.EndpointSubset) int {
+	sp, ok := httpServicePort(svc.Spec.Ports)
+	if !ok {
+		return 80
+	}
+	for _, ep := range subset.Ports {
+		if ep.Name == sp.Name {
+			return ep.Port
+		}
+	}
+	return 0
+}
+
+// Helper to resolve the port on the pod which a service port targets, returns 0 if the
+// pod does not expose it.
+func targetPort(sp api.ServicePort, p api.Pod) int {
+	switch {
+	case sp.TargetPort.Kind == util.IntstrInt && sp.TargetPort.IntVal > 0:
+		return sp.TargetPort.IntVal
+	case sp.TargetPort.Kind == util.IntstrString && sp.TargetPort.StrVal != "":
+		for _, c := range p.Spec.Containers {
+			for _, cp := range c.Ports {
+				if cp.Name == sp.TargetPort.StrVal {
+					return cp.ContainerPort
+				}
+			}
+		}
+		return 0
+	}
+
+	// The target port defaults to the port of the service.
+	return sp.Port
 }
 
 // Standard method for loading a Services object.
-func NewServices(c *client.Client) *Services {
+func NewServices(ctx context.Context, c *client.Client, onChange func()) *Services {
 	s := &Services{
-		Client: c,
-		List:   make(map[string][]string),
+		Client:       c,
+		List:         make(map[string][]string),
+		Ports:        make(map[string]map[int][]string),
+		ServicePorts: make(map[string][]api.ServicePort),
+		OnChange:     onChange,
 	}
 
 	// Start the continual process of pull the services and
 	// associated pods.
-	go s.Start()
+	go s.Start(ctx)
 
 	// Return the object so we can query it.
 	return s
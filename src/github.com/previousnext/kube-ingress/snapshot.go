@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Version of the snapshot format, bumped whenever the Backend changes in a way older
+// snapshots can't be loaded into.
+const snapshotVersion = 1
+
+// Snapshot is the last backend applied to nginx, saved so it can be served after a restart
+// while the API is unavailable.
+type Snapshot struct {
+	Version int       `json:"version"`
+	Saved   time.Time `json:"saved"`
+	Backend Backend   `json:"backend"`
+
+	// Contents of the certificate files the backend references, so they can be restored when the
+	// snapshot is served without them.
+	Files map[string][]byte `json:"files,omitempty"`
+}
+
+// Saves the backend to a file. The snapshot is written next to the file and then renamed
+// over it, so a crash never leaves a partial snapshot behind.
+func SaveSnapshot(path string, b Backend) error {
+	files := make(map[string][]byte)
+	for _, f := range certificateFiles(b) {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			fmt.Printf("Not saving the certificate %s with the snapshot: %v\n", f, err)
+			continue
+		}
+		files[f] = data
+	}
+
+	data, err := json.Marshal(Snapshot{
+		Version: snapshotVersion,
+		Saved:   time.Now(),
+		Backend: b,
+		Files:   files,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// Loads the backend from a snapshot file, restoring the certificates it references unless it is a
+// dry run.
+func LoadSnapshot(path string, dryRun bool) (Backend, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Backend{}, err
+	}
+
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return Backend{}, errors.New(fmt.Sprintf("Invalid snapshot %s: %v", path, err))
+	}
+
+	if s.Version != snapshotVersion {
+		return Backend{}, errors.New(fmt.Sprintf("Unsupported snapshot version %d, expected %d", s.Version, snapshotVersion))
+	}
+
+	// Nginx won't start with a missing certificate, so the ones which can't be restored are
+	// dropped from the servers using them.
+	missing := make(map[string]bool)
+	for _, f := range certificateFiles(s.Backend) {
+		if _, err := os.Stat(f); err == nil {
+			continue
+		}
+		if data, ok := s.Files[f]; ok && !dryRun {
+			err := restoreFile(f, data)
+			if err == nil {
+				continue
+			}
+			fmt.Printf("Failed to restore the certificate %s: %v\n", f, err)
+		}
+		missing[f] = true
+	}
+	if len(missing) > 0 {
+		dropMissingCertificates(&s.Backend, missing)
+	}
+
+	fmt.Printf("Loaded the snapshot saved at %v\n", s.Saved)
+
+	return s.Backend, nil
+}
+
+// Helper to write a file of a snapshot, creating its directory.
+func restoreFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// Serves the servers using a missing certificate without TLS, and stops serving the locations
+// which verify their backend with a missing certificate authority.
+func dropMissingCertificates(b *Backend, missing map[string]bool) {
+	for host, server := range b.Servers {
+		if cert := server.SSLCert; cert != nil && (missing[cert.PemFile] || missing[cert.CAFile]) {
+			fmt.Printf("Serving %s from the snapshot without TLS, its certificate is missing\n", host)
+			server.SSLCert = nil
+		}
+
+		var locations []Location
+		for _, l := range server.Locations {
+			if l.UpstreamTLS != nil && missing[l.UpstreamTLS.CAFile] {
+				fmt.Printf("Not serving %s%s from the snapshot, the certificate authority of its backend is missing\n", host, l.Path)
+				continue
+			}
+			locations = append(locations, l)
+		}
+		server.Locations = locations
+
+		b.Servers[host] = server
+	}
+}
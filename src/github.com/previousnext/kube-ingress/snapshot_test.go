@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-ingress")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	pem := filepath.Join(dir, "default-web-tls.pem")
+	assert.Nil(t, ioutil.WriteFile(pem, []byte("cert"), 0600))
+
+	var (
+		svcs = map[string][]string{
+			"default-web": []string{"1.2.3.4:80"},
+		}
+		certs = map[string]SSLCert{
+			"default-web-tls": SSLCert{PemFile: pem, Checksum: "abc"},
+		}
+		path = filepath.Join(dir, "state", "backend.json")
+	)
+
+	b := testBuild(Config{SSLRedirect: true}, svcs, certs,
+		testIngress("default", "web", "example.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/tls-secret": "web-tls",
+			"ingress.kubernetes.io/limit-rps":  "10",
+		}),
+		testIngress("default", "canary", "example.com", "/", "web", map[string]string{
+			"ingress.kubernetes.io/canary":        "true",
+			"ingress.kubernetes.io/canary-weight": "5",
+		}),
+	)
+
+	assert.Nil(t, SaveSnapshot(path, b))
+	loaded, err := LoadSnapshot(path, false)
+	assert.Nil(t, err)
+	assert.Equal(t, b, loaded, "The backend is restored as it was saved")
+
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	assert.Nil(t, err)
+	assert.Len(t, files, 1, "No temporary files are left behind")
+
+	// Certificates which are gone are restored from the snapshot.
+	assert.Nil(t, os.Remove(pem))
+	loaded, err = LoadSnapshot(path, false)
+	assert.Nil(t, err)
+	assert.Equal(t, b, loaded)
+	data, err := ioutil.ReadFile(pem)
+	assert.Nil(t, err)
+	assert.Equal(t, "cert", string(data))
+
+	// Without the certificate only TLS is dropped, a dry run doesn't restore it.
+	assert.Nil(t, os.Remove(pem))
+	loaded, err = LoadSnapshot(path, true)
+	assert.Nil(t, err)
+	assert.Nil(t, loaded.Servers["example.com"].SSLCert)
+	assert.Equal(t, b.Servers["example.com"].Locations, loaded.Servers["example.com"].Locations)
+	_, err = os.Stat(pem)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"version": 99, "backend": {}}`), 0600))
+	_, err = LoadSnapshot(path, false)
+	assert.Equal(t, "Unsupported snapshot version 99, expected 1", err.Error())
+
+	_, err = LoadSnapshot(filepath.Join(dir, "missing.json"), false)
+	assert.NotNil(t, err)
+}
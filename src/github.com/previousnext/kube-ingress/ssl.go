@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// HSTS is the Strict-Transport-Security policy sent to clients.
+type HSTS struct {
+	MaxAge            int
+	IncludeSubdomains bool
+	Preload           bool
+}
+
+// Header returns the value of the Strict-Transport-Security header.
+func (h HSTS) Header() string {
+	v := fmt.Sprintf("max-age=%d", h.MaxAge)
+	if h.IncludeSubdomains {
+		v += "; includeSubDomains"
+	}
+	if h.Preload {
+		v += "; preload"
+	}
+	return v
+}
+
+// SSLPolicy holds the HTTPS settings of an ingress.
+type SSLPolicy struct {
+	// Redirect plain HTTP to HTTPS when the host has a certificate.
+	Redirect bool
+
+	// Redirect plain HTTP to HTTPS based on X-Forwarded-Proto, used when TLS is
+	// terminated before the request reaches nginx.
+	ForceRedirect bool
+
+	// The Strict-Transport-Security policy, nil when disabled.
+	HSTS *HSTS
+}
+
+// Loads the HTTPS settings of an ingress, falling back to the global configuration.
+func parseSSLPolicy(i extensions.Ingress, c Config) (SSLPolicy, error) {
+	var (
+		p   SSLPolicy
+		h   = c.HSTS
+		err error
+	)
+
+	if p.Redirect, err = annotationBool(i, "ssl-redirect", c.SSLRedirect); err != nil {
+		return p, err
+	}
+	if p.ForceRedirect, err = annotationBool(i, "force-ssl-redirect", false); err != nil {
+		return p, err
+	}
+
+	enabled, err := annotationBool(i, "hsts", c.HSTSEnabled)
+	if err != nil {
+		return p, err
+	}
+	if h.MaxAge, err = annotationInt(i, "hsts-max-age", h.MaxAge); err != nil {
+		return p, err
+	}
+	if h.IncludeSubdomains, err = annotationBool(i, "hsts-include-subdomains", h.IncludeSubdomains); err != nil {
+		return p, err
+	}
+	if h.Preload, err = annotationBool(i, "hsts-preload", h.Preload); err != nil {
+		return p, err
+	}
+
+	if enabled {
+		p.HSTS = &h
+	}
+
+	return p, nil
+}
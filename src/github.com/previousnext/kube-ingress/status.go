@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Status reports the health, readiness and metrics of the controller.
+type Status struct {
+	Process *Process
+
+	// Forces a sync of the configuration.
+	Force func()
+
+	// The changes made by the last render.
+	Diffs *DiffLog
+
+	// Explains how a request is routed.
+	Explain func(host, path string) Explanation
+
+	// nginx is never started during a dry run.
+	DryRun bool
+
+	mu       sync.Mutex
+	draining bool
+}
+
+// Reports the controller as healthy.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// Marks the controller as no longer ready so load balancers stop sending it new connections.
+func (s *Status) Drain() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.draining = true
+}
+
+// Reports the controller as ready once nginx is serving and until it is draining.
+func (s *Status) Readyz(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	draining := s.draining
+	s.mu.Unlock()
+
+	switch {
+	case draining:
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+	case !s.DryRun && !s.Process.Running():
+		http.Error(w, "nginx is not running", http.StatusServiceUnavailable)
+	default:
+		w.Write([]byte("ok"))
+	}
+}
+
+// Forces a sync which is applied regardless of how many routes it drops. It skips the guard against
+// dropping routes, so only requests from the pod itself are accepted, eg. through kubectl exec.
+func (s *Status) ForceSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+		http.Error(w, "syncs can only be forced from localhost", http.StatusForbidden)
+		return
+	}
+
+	fmt.Println("Forcing a sync")
+	s.Force()
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("sync forced"))
+}
+
+// Reports the changes made by the last render, as text or as JSON with ?output=json.
+func (s *Status) Diff(w http.ResponseWriter, r *http.Request) {
+	d := s.Diffs.Last()
+
+	if r.URL.Query().Get("output") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+		return
+	}
+
+	if d.Time.IsZero() {
+		w.Write([]byte("Nothing has been rendered yet\n"))
+		return
+	}
+
+	fmt.Fprintf(w, "Rendered at %s\n\n", d.Time.Format(time.RFC3339))
+	for _, c := range d.Changes {
+		fmt.Fprintf(w, "%s\n", c)
+	}
+	fmt.Fprintf(w, "\n%s", d.Text)
+}
+
+// Explains how a request for the host and path parameters is routed, as text or as JSON with ?output=json.
+func (s *Status) ExplainRequest(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Query().Get("host")
+	if host == "" {
+		http.Error(w, "the host parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = "/"
+	}
+
+	e := s.Explain(host, path)
+
+	if r.URL.Query().Get("output") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	w.Write([]byte(e.String()))
+}
+
+// Starts the status server, serving until the context is cancelled.
+func (s *Status) Start(ctx context.Context, port string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", Healthz)
+	mux.HandleFunc("/readyz", s.Readyz)
+	mux.HandleFunc("/force-sync", s.ForceSync)
+	mux.HandleFunc("/debug/diff", s.Diff)
+	mux.HandleFunc("/debug/explain", s.ExplainRequest)
+	mux.Handle("/metrics", prometheus.Handler())
+
+	err := serve(ctx, &http.Server{Addr: ":" + port, Handler: mux})
+	if err != nil {
+		fmt.Printf("Status server stopped: %v\n", err)
+	}
+}
+
+// Standard method for loading a Status object.
+func NewStatus(p *Process) *Status {
+	return &Status{
+		Process: p,
+		Diffs:   &DiffLog{},
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadyz(t *testing.T) {
+	p := NewProcess("nginx", "nginx.conf", false)
+	s := NewStatus(p)
+
+	w := httptest.NewRecorder()
+	s.Readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "Not ready until nginx is running")
+
+	p.cmd = &exec.Cmd{}
+	w = httptest.NewRecorder()
+	s.Readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	s.Drain()
+	w = httptest.NewRecorder()
+	s.Readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "Not ready while draining")
+	assert.Equal(t, "draining\n", w.Body.String())
+}
+
+func TestForceSync(t *testing.T) {
+	var forced int
+
+	s := NewStatus(nil)
+	s.Force = func() { forced++ }
+
+	r := httptest.NewRequest("POST", "/force-sync", nil)
+	w := httptest.NewRecorder()
+	s.ForceSync(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code, "Syncs are not forced from other pods")
+	assert.Equal(t, 0, forced)
+
+	r.RemoteAddr = "127.0.0.1:41234"
+	w = httptest.NewRecorder()
+	s.ForceSync(w, r)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, 1, forced)
+}
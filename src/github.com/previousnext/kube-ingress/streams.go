@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// Stream exposes a service port on the edge with the nginx stream module.
+type Stream struct {
+	// The service and port as namespace/service:port.
+	Service   string
+	Addresses []string
+}
+
+// Loads a file mapping external ports to services, eg. "5432: default/postgres:5432".
+// This has the same format as the data of a ConfigMap so one can be mounted in.
+func LoadStreamServices(path string) (map[string]string, error) {
+	l := make(map[string]string)
+	if path == "" {
+		return l, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return l, err
+	}
+
+	if err := yaml.Unmarshal(data, &l); err != nil {
+		return l, errors.New(fmt.Sprintf("Failed to parse %s: %v", path, err))
+	}
+
+	return l, nil
+}
+
+// Helper to split a port and a namespace/service:port pair into the parts used for lookups.
+func parseStreamService(port, target string) (string, int, error) {
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return "", 0, errors.New(fmt.Sprintf("Invalid port: %s", port))
+	}
+
+	i := strings.LastIndex(target, ":")
+	if i < 0 {
+		return "", 0, errors.New(fmt.Sprintf("Service %s must be in the format namespace/service:port", target))
+	}
+
+	parts := strings.Split(target[:i], "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", 0, errors.New(fmt.Sprintf("Service %s must be in the format namespace/service:port", target))
+	}
+
+	p, err := strconv.Atoi(target[i+1:])
+	if err != nil || p <= 0 {
+		return "", 0, errors.New(fmt.Sprintf("Invalid service port: %s", target))
+	}
+
+	return MergeNameNameSpace(parts[0], parts[1]), p, nil
+}
+
+// Builds the streams for a set of port mappings.
+func (bu *Builder) streams(l map[string]string) map[string]Stream {
+	streams := make(map[string]Stream)
+
+	for port, target := range l {
+		name, p, err := parseStreamService(port, target)
+		if err != nil {
+			bu.logf("Skipping stream %s: %s\n", port, err)
+			continue
+		}
+
+		addrs, err := bu.Services.GetPort(name, p)
+		if err != nil {
+			bu.logf("Failed to get service pods: %s\n", err)
+			continue
+		}
+
+		streams[port] = Stream{
+			Service:   target,
+			Addresses: addrs,
+		}
+	}
+
+	return streams
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/kubernetes/pkg/util/workqueue"
+)
+
+// Key used for all changes, the queue collapses them into a single sync.
+const syncKey = "sync"
+
+// Delays before a failed sync is retried, doubling each time it fails again.
+const (
+	syncRetryDelay    = time.Second
+	syncMaxRetryDelay = 5 * time.Minute
+)
+
+var (
+	syncChanges = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kube_ingress_sync_batched_changes",
+		Help:    "Number of changes applied by each sync of the nginx configuration.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 8),
+	})
+	syncDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "kube_ingress_sync_duration_seconds",
+		Help: "Time taken to sync the nginx configuration.",
+	})
+	syncErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kube_ingress_sync_errors_total",
+		Help: "Number of syncs of the nginx configuration which failed.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(syncChanges)
+	prometheus.MustRegister(syncDuration)
+	prometheus.MustRegister(syncErrors)
+}
+
+// SyncQueue coalesces bursts of changes into a single sync of the nginx configuration.
+type SyncQueue struct {
+	Queue *workqueue.Type
+
+	// Minimum time between syncs, changes arriving within this time of the last sync
+	// or change are batched together.
+	MinInterval time.Duration
+
+	// Maximum time a change waits for the burst it is part of to finish.
+	MaxDelay time.Duration
+
+	// Delay before retrying a failed sync, doubled for each failure up to the maximum.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+
+	// Applies the latest state to nginx, returns ErrNotChanged or ErrNotReady when
+	// nginx was not reloaded.
+	Sync func() error
+
+	mu       sync.Mutex
+	changes  int
+	failures int
+	first    time.Time
+	last     time.Time
+	lastSync time.Time
+}
+
+// Enqueue records a change which needs to be synced.
+func (q *SyncQueue) Enqueue() {
+	q.mu.Lock()
+	now := time.Now()
+	if q.changes == 0 {
+		q.first = now
+	}
+	q.changes++
+	q.last = now
+	q.mu.Unlock()
+
+	q.Queue.Add(syncKey)
+}
+
+// Run processes the queue until the context is cancelled.
+func (q *SyncQueue) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		q.Queue.ShutDown()
+	}()
+
+	for {
+		key, shutdown := q.Queue.Get()
+		if shutdown {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			q.Queue.Done(key)
+			return
+		case <-time.After(q.wait(time.Now())):
+		}
+
+		q.mu.Lock()
+		changes := q.changes
+		q.changes = 0
+		q.mu.Unlock()
+
+		// Changes which arrived while waiting are part of this sync.
+		if changes > 0 {
+			start := time.Now()
+
+			// Only syncs which reloaded nginx are measured.
+			switch err := q.Sync(); err {
+			case nil:
+				syncChanges.Observe(float64(changes))
+				syncDuration.Observe(time.Since(start).Seconds())
+				q.reset()
+			case ErrNotChanged:
+				q.reset()
+			case ErrNotReady:
+				q.retry(changes)
+			default:
+				fmt.Println(err)
+				syncErrors.Inc()
+				q.retry(changes)
+			}
+
+			q.mu.Lock()
+			q.lastSync = time.Now()
+			q.mu.Unlock()
+		}
+
+		q.Queue.Done(key)
+	}
+}
+
+// Clears the failures once a sync has been applied.
+func (q *SyncQueue) reset() {
+	q.mu.Lock()
+	q.failures = 0
+	q.mu.Unlock()
+}
+
+// Puts the changes of a sync which was not applied back on the queue, backing off
+// while the syncs keep failing.
+func (q *SyncQueue) retry(changes int) {
+	q.mu.Lock()
+	if q.changes == 0 {
+		q.first = time.Now()
+	}
+	q.changes += changes
+	q.failures++
+	delay := q.retryDelay(q.failures)
+	q.mu.Unlock()
+
+	time.AfterFunc(delay, func() {
+		q.Queue.Add(syncKey)
+	})
+}
+
+// Returns how long to wait before retrying after a number of failed syncs.
+func (q *SyncQueue) retryDelay(failures int) time.Duration {
+	delay := q.RetryDelay
+	for n := 1; n < failures && delay < q.MaxRetryDelay; n++ {
+		delay *= 2
+	}
+	if delay > q.MaxRetryDelay {
+		return q.MaxRetryDelay
+	}
+	return delay
+}
+
+// Returns how long to wait before syncing. We wait until the minimum interval has passed
+// since both the last sync and the last change, unless the first change has been waiting
+// for the maximum delay.
+func (q *SyncQueue) wait(now time.Time) time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.changes == 0 {
+		return 0
+	}
+
+	ready := q.lastSync.Add(q.MinInterval)
+	if settled := q.last.Add(q.MinInterval); settled.After(ready) {
+		ready = settled
+	}
+
+	// The maximum delay never syncs more often than the minimum interval allows.
+	if forced := q.first.Add(q.MaxDelay); forced.Before(ready) {
+		ready = forced
+		if min := q.lastSync.Add(q.MinInterval); min.After(ready) {
+			ready = min
+		}
+	}
+
+	if ready.Before(now) {
+		return 0
+	}
+	return ready.Sub(now)
+}
+
+// Standard method for loading a SyncQueue object.
+func NewSyncQueue(min, max time.Duration, sync func() error) *SyncQueue {
+	return &SyncQueue{
+		Queue:         workqueue.New(),
+		MinInterval:   min,
+		MaxDelay:      max,
+		RetryDelay:    syncRetryDelay,
+		MaxRetryDelay: syncMaxRetryDelay,
+		Sync:          sync,
+	}
+}
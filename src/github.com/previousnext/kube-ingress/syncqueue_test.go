@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncQueueCoalesce(t *testing.T) {
+	syncs := make(chan bool, 10)
+
+	q := NewSyncQueue(50*time.Millisecond, time.Second, func() error {
+		syncs <- true
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go q.Run(ctx)
+
+	for i := 0; i < 5; i++ {
+		q.Enqueue()
+	}
+
+	select {
+	case <-syncs:
+	case <-time.After(time.Second):
+		t.Fatal("Changes were not synced")
+	}
+
+	select {
+	case <-syncs:
+		t.Fatal("A burst of changes is synced once")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestSyncQueueRetry(t *testing.T) {
+	syncs := make(chan bool, 10)
+	fail := true
+
+	q := NewSyncQueue(10*time.Millisecond, time.Second, func() error {
+		syncs <- fail
+		if fail {
+			fail = false
+			return errors.New("Failed to reload")
+		}
+		return nil
+	})
+	q.RetryDelay = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go q.Run(ctx)
+
+	q.Enqueue()
+
+	for _, failed := range []bool{true, false} {
+		select {
+		case f := <-syncs:
+			assert.Equal(t, failed, f)
+		case <-time.After(time.Second):
+			t.Fatal("A failed sync is retried")
+		}
+	}
+
+	select {
+	case <-syncs:
+		t.Fatal("Syncs are not retried once applied")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestSyncQueueRetryDelay(t *testing.T) {
+	q := NewSyncQueue(time.Second, time.Second, nil)
+
+	assert.Equal(t, time.Second, q.retryDelay(1))
+	assert.Equal(t, 4*time.Second, q.retryDelay(3))
+	assert.Equal(t, 5*time.Minute, q.retryDelay(100), "The delay is capped")
+}
+
+func TestSyncQueueWait(t *testing.T) {
+	var (
+		q   = NewSyncQueue(10*time.Second, 30*time.Second, nil)
+		now = time.Now()
+	)
+
+	assert.Equal(t, time.Duration(0), q.wait(now), "Nothing to wait for without changes")
+
+	q.changes = 3
+	q.first = now.Add(-5 * time.Second)
+	q.last = now.Add(-2 * time.Second)
+	assert.Equal(t, 8*time.Second, q.wait(now), "Waits for the changes to settle")
+
+	q.lastSync = now.Add(-1 * time.Second)
+	assert.Equal(t, 9*time.Second, q.wait(now), "Waits for the minimum interval since the last sync")
+
+	q.first = now.Add(-25 * time.Second)
+	q.last = now
+	q.lastSync = now.Add(-20 * time.Second)
+	assert.Equal(t, 5*time.Second, q.wait(now), "Changes which keep arriving are synced after the maximum delay")
+
+	q.lastSync = now.Add(-4 * time.Second)
+	assert.Equal(t, 6*time.Second, q.wait(now), "The maximum delay still respects the minimum interval")
+}
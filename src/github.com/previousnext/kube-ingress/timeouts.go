@@ -0,0 +1,29 @@
+package main
+
+import (
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// Timeouts for proxied connections, in seconds. Long lived streams such as WebSockets
+// are closed when no data is read or sent within these.
+type Timeouts struct {
+	Read int
+	Send int
+}
+
+// Loads the timeouts of an ingress, falling back to the global configuration.
+func parseTimeouts(i extensions.Ingress, c Config) (Timeouts, error) {
+	var (
+		t   Timeouts
+		err error
+	)
+
+	if t.Read, err = annotationInt(i, "proxy-read-timeout", c.Timeouts.Read); err != nil {
+		return c.Timeouts, err
+	}
+	if t.Send, err = annotationInt(i, "proxy-send-timeout", c.Timeouts.Send); err != nil {
+		return c.Timeouts, err
+	}
+
+	return t, nil
+}
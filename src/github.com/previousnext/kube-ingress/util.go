@@ -1,21 +1,85 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"os/exec"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/kubernetes/pkg/util"
 )
 
-// Helper function execute commands on the commandline.
-func shellOut(cmd string) error {
-	out, err := exec.Command("sh", "-c", cmd).CombinedOutput()
-	if err != nil {
-		return errors.New(fmt.Sprintf("Failed to execute %v: %v, err: %v", cmd, string(out), err))
+// Helper to wait for the rate limiter, returns false once the context has been cancelled.
+func accept(ctx context.Context, rl util.RateLimiter) bool {
+	for !rl.CanAccept() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return ctx.Err() == nil
+}
+
+// Helper to serve HTTP until the context is cancelled, open requests are given a moment to finish.
+// HTTPS is served when the server has a TLS configuration.
+func serve(ctx context.Context, srv *http.Server) error {
+	errs := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			errs <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
 	}
-	return nil
+
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return srv.Shutdown(shutdown)
 }
 
 // Helper to merge the name and namespace of a service.
 func MergeNameNameSpace(ns, n string) string {
 	return ns + "-" + n
 }
+
+// Helper to split a comma separated list, dropping any empty entries.
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		l = append(l, v)
+	}
+	return l
+}
+
+// Helper to build a name which is safe to use for nginx zones and variables. A hash
+// of the namespace and name is appended so similar names cannot collide.
+func zoneName(ns, n string) string {
+	h := fnv.New32a()
+	h.Write([]byte(ns + "/" + n))
+
+	return fmt.Sprintf("%s_%s_%08x", safeName(ns), safeName(n), h.Sum32())
+}
+
+// Helper to replace any characters which are not allowed in nginx variable names.
+func safeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// AdmissionReview is the request sent by the API server to a validating webhook, and the response
+// sent back. Only the fields used to validate ingresses are declared.
+type AdmissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *AdmissionRequest  `json:"request,omitempty"`
+	Response   *AdmissionResponse `json:"response,omitempty"`
+}
+
+type AdmissionRequest struct {
+	UID       string          `json:"uid"`
+	Kind      AdmissionKind   `json:"kind"`
+	Name      string          `json:"name"`
+	Namespace string          `json:"namespace"`
+	Operation string          `json:"operation"`
+	Object    json.RawMessage `json:"object,omitempty"`
+}
+
+type AdmissionKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+type AdmissionResponse struct {
+	UID      string           `json:"uid"`
+	Allowed  bool             `json:"allowed"`
+	Result   *AdmissionStatus `json:"status,omitempty"`
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+type AdmissionStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Problems found with these rules deny an ingress. The others are returned as warnings, eg. so an ingress
+// can be created before its service.
+var webhookDenyRules = map[string]bool{
+	"annotation": true,
+	"canary":     true,
+	"conflict":   true,
+	"host":       true,
+	"path":       true,
+}
+
+// API versions of the ingresses which can be validated, the others are admitted without being checked.
+var webhookAPIVersions = map[string]bool{
+	"extensions/v1beta1":        true,
+	"networking.k8s.io/v1beta1": true,
+}
+
+// Webhook validates ingresses before they are admitted, with the rules the controller applies when building
+// them and against the ingresses it has loaded.
+type Webhook struct {
+	Builder *Builder
+
+	// Returns the ingresses of the cluster, false if they haven't been loaded yet.
+	Ingresses func() ([]extensions.Ingress, bool)
+
+	// Certificate the webhook is served with.
+	Certificate tls.Certificate
+}
+
+// Handles an AdmissionReview.
+func (wh *Webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var review AdmissionReview
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, "expected an AdmissionReview request", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = wh.Review(review.Request)
+	review.Response.UID = review.Request.UID
+	review.Request = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+// Reviews the ingress of an admission request.
+func (wh *Webhook) Review(req *AdmissionRequest) *AdmissionResponse {
+	// Only the ingresses being created or changed are validated.
+	if req.Kind.Kind != "Ingress" || (req.Operation != "CREATE" && req.Operation != "UPDATE") {
+		return &AdmissionResponse{Allowed: true}
+	}
+
+	// Failing open, denying the ingresses of newer API versions would block every deployment using them.
+	if version := req.Kind.Group + "/" + req.Kind.Version; !webhookAPIVersions[version] {
+		return &AdmissionResponse{
+			Allowed:  true,
+			Warnings: []string{fmt.Sprintf("kube-ingress cannot validate %s ingresses, the ingress was not checked", version)},
+		}
+	}
+
+	i, err := decodeAdmissionIngress(req.Object)
+	if err != nil {
+		return deny(fmt.Sprintf("Failed to decode the ingress: %v", err))
+	}
+	if i.ObjectMeta.Namespace == "" {
+		i.ObjectMeta.Namespace = req.Namespace
+	}
+	if i.ObjectMeta.Name == "" {
+		i.ObjectMeta.Name = req.Name
+	}
+	defaultNamespace(&i.ObjectMeta)
+
+	ings, ok := wh.Ingresses()
+	if !ok {
+		// Failing open, a controller which is starting shouldn't block every deployment.
+		return &AdmissionResponse{
+			Allowed:  true,
+			Warnings: []string{"kube-ingress has not loaded the ingresses yet, conflicts were not checked"},
+		}
+	}
+
+	var (
+		reasons  []string
+		warnings []string
+	)
+
+	for _, p := range wh.validate(ings, i) {
+		if p.Severity == SeverityError && webhookDenyRules[p.Rule] {
+			reasons = append(reasons, p.Message)
+			continue
+		}
+		warnings = append(warnings, p.Message)
+	}
+
+	if len(reasons) > 0 {
+		resp := deny(strings.Join(reasons, "; "))
+		resp.Warnings = warnings
+		return resp
+	}
+
+	return &AdmissionResponse{
+		Allowed:  true,
+		Warnings: warnings,
+	}
+}
+
+// Returns the problems with an ingress when it is added to the others, replacing the previous version of
+// itself. It is built last so it is the one reported when it conflicts with an existing ingress.
+func (wh *Webhook) validate(ings []extensions.Ingress, i extensions.Ingress) []Problem {
+	var l []extensions.Ingress
+	for _, existing := range ings {
+		if existing.ObjectMeta.Namespace != i.ObjectMeta.Namespace || existing.ObjectMeta.Name != i.ObjectMeta.Name {
+			l = append(l, existing)
+		}
+	}
+
+	// A builder of our own, as the one of the controller is used by its syncs.
+	bu := &Builder{
+		Config:   wh.Builder.Config,
+		Services: wh.Builder.Services,
+		Secrets:  wh.Builder.Secrets,
+		Quiet:    true,
+	}
+	bu.Build(append(l, i))
+
+	var problems []Problem
+	for _, p := range bu.Problems {
+		if p.Namespace == i.ObjectMeta.Namespace && p.Name == i.ObjectMeta.Name {
+			problems = append(problems, p)
+		}
+	}
+
+	return problems
+}
+
+// Decodes the ingress of an admission request. networking.k8s.io/v1beta1 ingresses share the schema of
+// extensions/v1beta1, so they are decoded as those.
+func decodeAdmissionIngress(data []byte) (extensions.Ingress, error) {
+	var meta struct {
+		APIVersion string `json:"apiVersion"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return extensions.Ingress{}, err
+	}
+
+	if meta.APIVersion == "networking.k8s.io/v1beta1" {
+		var obj map[string]interface{}
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return extensions.Ingress{}, err
+		}
+		obj["apiVersion"] = "extensions/v1beta1"
+
+		converted, err := json.Marshal(obj)
+		if err != nil {
+			return extensions.Ingress{}, err
+		}
+		data = converted
+	}
+
+	obj, err := api.Scheme.Decode(data)
+	if err != nil {
+		return extensions.Ingress{}, err
+	}
+
+	i, ok := obj.(*extensions.Ingress)
+	if !ok {
+		return extensions.Ingress{}, errors.New(fmt.Sprintf("expected an Ingress, got: %T", obj))
+	}
+
+	return *i, nil
+}
+
+// Helper to build a response denying the request.
+func deny(message string) *AdmissionResponse {
+	return &AdmissionResponse{
+		Allowed: false,
+		Result: &AdmissionStatus{
+			Code:    http.StatusForbidden,
+			Message: message,
+		},
+	}
+}
+
+// Starts the webhook server, serving HTTPS until the context is cancelled.
+func (wh *Webhook) Start(ctx context.Context, port string) {
+	mux := http.NewServeMux()
+	mux.Handle("/validate", wh)
+
+	err := serve(ctx, &http.Server{
+		Addr:      ":" + port,
+		Handler:   mux,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{wh.Certificate}},
+	})
+	if err != nil {
+		fmt.Printf("Webhook server stopped: %v\n", err)
+	}
+}
+
+// Standard method for loading a Webhook object.
+func NewWebhook(builder *Builder, ingresses func() ([]extensions.Ingress, bool), certFile, keyFile string) (*Webhook, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return &Webhook{}, errors.New(fmt.Sprintf("Failed to load the webhook certificate: %v", err))
+	}
+
+	return &Webhook{
+		Builder:     builder,
+		Ingresses:   ingresses,
+		Certificate: cert,
+	}, nil
+}
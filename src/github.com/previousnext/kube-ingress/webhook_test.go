@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// Starts the webhook over HTTPS with the manifests in testdata as the state of the cluster.
+func testWebhookServer(t *testing.T) *httptest.Server {
+	m, err := LoadManifests([]string{"testdata/ingresses.yaml", "testdata/services.yaml", "testdata/endpoints.yaml"})
+	assert.Nil(t, err)
+
+	wh := &Webhook{
+		Builder: manifestBuilder(Config{}, m),
+		Ingresses: func() ([]extensions.Ingress, bool) {
+			return m.Ingresses, true
+		},
+	}
+
+	return httptest.NewTLSServer(wh)
+}
+
+// Sends a fixture from testdata/admission to the webhook.
+func testAdmissionReview(t *testing.T, srv *httptest.Server, fixture string) AdmissionReview {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "admission", fixture))
+	assert.Nil(t, err)
+
+	resp, err := srv.Client().Post(srv.URL, "application/json", bytes.NewReader(data))
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var review AdmissionReview
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&review))
+
+	return review
+}
+
+func TestWebhook(t *testing.T) {
+	srv := testWebhookServer(t)
+	defer srv.Close()
+
+	tests := []struct {
+		Fixture  string
+		Allowed  bool
+		Message  string
+		Warnings []string
+	}{
+		{Fixture: "create-valid.json", Allowed: true},
+		{Fixture: "create-conflict.json", Message: "Skipping example.com/ for ingress default/blog: the path is already routed by default/web"},
+		{Fixture: "create-invalid-annotation.json", Message: "Ignoring rate limits for ingress default/limited: Annotation ingress.kubernetes.io/limit-rps must be a non-negative number, got: fast"},
+		{Fixture: "create-invalid-host.json", Message: "Skipping Shop.example.com for ingress default/upper: the host must be a lowercase DNS name"},
+		{Fixture: "create-misspelled-annotation.json", Allowed: true, Warnings: []string{
+			"Ignoring the unknown annotation ingress.kubernetes.io/ssl-redirct on ingress default/typo, did you mean ingress.kubernetes.io/ssl-redirect?",
+		}},
+		{Fixture: "create-missing-service.json", Allowed: true, Warnings: []string{
+			"Skipping early.example.com/ for ingress default/early: Cannot find the service: default-not-deployed-yet",
+		}},
+		{Fixture: "update-existing.json", Allowed: true},
+		{Fixture: "create-networking-v1beta1.json", Message: "Skipping example.com/ for ingress default/next: the path is already routed by default/web"},
+		{Fixture: "create-networking-v1.json", Allowed: true, Warnings: []string{
+			"kube-ingress cannot validate networking.k8s.io/v1 ingresses, the ingress was not checked",
+		}},
+		{Fixture: "delete.json", Allowed: true},
+	}
+
+	for _, test := range tests {
+		review := testAdmissionReview(t, srv, test.Fixture)
+
+		assert.Equal(t, "admission.k8s.io/v1", review.APIVersion, test.Fixture)
+		assert.Nil(t, review.Request, test.Fixture)
+		if !assert.NotNil(t, review.Response, test.Fixture) {
+			continue
+		}
+		assert.NotEmpty(t, review.Response.UID, test.Fixture)
+		assert.Equal(t, test.Allowed, review.Response.Allowed, test.Fixture)
+		assert.Equal(t, test.Warnings, review.Response.Warnings, test.Fixture)
+
+		if test.Allowed {
+			assert.Nil(t, review.Response.Result, test.Fixture)
+			continue
+		}
+		if assert.NotNil(t, review.Response.Result, test.Fixture) {
+			assert.Equal(t, http.StatusForbidden, review.Response.Result.Code, test.Fixture)
+			assert.Equal(t, test.Message, review.Response.Result.Message, test.Fixture)
+		}
+	}
+}
+
+func TestWebhookNotLoaded(t *testing.T) {
+	wh := &Webhook{
+		Builder: &Builder{Services: &Services{}, Secrets: &Secrets{}},
+		Ingresses: func() ([]extensions.Ingress, bool) {
+			return nil, false
+		},
+	}
+
+	resp := wh.Review(&AdmissionRequest{
+		Kind:      AdmissionKind{Group: "extensions", Version: "v1beta1", Kind: "Ingress"},
+		Operation: "CREATE",
+		Object:    []byte(`{"apiVersion": "extensions/v1beta1", "kind": "Ingress", "metadata": {"name": "web"}}`),
+	})
+	assert.True(t, resp.Allowed, "Ingresses are admitted until the controller has loaded the others")
+	assert.Len(t, resp.Warnings, 1)
+}
+
+func TestNewWebhook(t *testing.T) {
+	_, err := NewWebhook(&Builder{}, nil, "testdata/missing.crt", "testdata/missing.key")
+	assert.NotNil(t, err, "The controller doesn't start without the webhook certificate")
+}